@@ -1,9 +1,13 @@
 package cache
 
 import (
+	"math"
 	"time"
 )
 
+// TTL of items without expiry, see Cache.TTL.
+const NoExpiry time.Duration = math.MinInt64
+
 type Cache interface {
 	// functions never using current time
 	Get(key string) (value interface{}, ok bool)
@@ -12,14 +16,16 @@ type Cache interface {
 	Clear() int
 	Len() int
 	Capacity() int
+	Keys() []string
+	TTL(key string) (ttl time.Duration, ok bool)
 
 	// use time.Now() if current time is neccessary to expire entries
-	Set(key string, value interface{}, expire time.Time)
+	Set(key string, value interface{}, expire time.Time) error
 	GetNotStale(key string) (value interface{}, ok bool)
 	Expire() int
 
 	// manually specify time used when neccessary to expire entries
-	SetNow(key string, value interface{}, expire time.Time, now time.Time)
+	SetNow(key string, value interface{}, expire time.Time, now time.Time) error
 	GetNotStaleNow(key string, now time.Time) (value interface{}, ok bool)
 	ExpireNow(now time.Time) int
 }
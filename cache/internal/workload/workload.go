@@ -0,0 +1,31 @@
+// Package workload replays key sequences against a cache, for the
+// tests comparing hit rates of the cache options.
+package workload
+
+import (
+	"time"
+
+	"github.com/majek/goplayground/cache"
+)
+
+// Look up the keys `key` gives for 0 <= i < n in order, setting those
+// that miss. Returns the fraction of lookups that hit, counting only
+// the keys `key` marks as counted, so that a workload can fill the
+// cache with keys it doesn't measure.
+func HitRate(c cache.Cache, n int, key func(i int) (k string, counted bool)) float64 {
+	hits, total := 0, 0
+	for i := 0; i < n; i++ {
+		k, counted := key(i)
+		_, ok := c.Get(k)
+		if !ok {
+			c.Set(k, "v", time.Time{})
+		}
+		if counted {
+			total += 1
+			if ok {
+				hits += 1
+			}
+		}
+	}
+	return float64(hits) / float64(total)
+}
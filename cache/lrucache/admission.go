@@ -0,0 +1,98 @@
+package lrucache
+
+import (
+	"errors"
+)
+
+// Returned by Set when the admission filter turned the new item down,
+// see WithAdmissionFilter.
+var ErrRejected = errors.New("lrucache: item rejected by the admission filter")
+
+// Count-min sketch estimating how often keys were accessed recently.
+// Counters saturate at 15 and are all halved every `period`
+// additions, so that old popularity fades.
+type freqSketch struct {
+	rows      [4][]uint8
+	mask      uint64
+	additions int
+	period    int
+}
+
+// Multipliers deriving the index in every row from one key hash.
+var sketchSeeds = [4]uint64{0x9e3779b97f4a7c15, 0xc2b2ae3d27d4eb4f, 0x165667b19e3779f9, 0xff51afd7ed558ccd}
+
+// Before evicting an item to make room for a new key, compare how
+// often both keys were accessed recently, and turn the new item down
+// with ErrRejected if it was accessed less than the item it would
+// replace. This is TinyLFU admission: under churn the hot items stay
+// in even when plain LRU would push them out for keys seen once.
+// Accesses are hits and misses of the lookups, counted in a sketch of
+// about 4 bytes per slot. Overwrites, free slots and expired items are
+// never turned down, and with WithStatsSampling all lookups still
+// count.
+func WithAdmissionFilter() Option {
+	return func(b *LRUCache) {
+		b.sketch = &freqSketch{}
+	}
+}
+
+func sketchWidth(capacity uint) uint64 {
+	width := uint64(16)
+	for width < uint64(capacity) {
+		width <<= 1
+	}
+	return width
+}
+
+func (s *freqSketch) init(capacity uint) {
+	width := sketchWidth(capacity)
+	s.mask = width - 1
+	s.period = 10 * int(width)
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+}
+
+func (s *freqSketch) index(hash uint64, row int) uint64 {
+	h := hash * sketchSeeds[row]
+	return (h ^ h>>32) & s.mask
+}
+
+// Count an access of the key. O(1), O(capacity) every period.
+func (s *freqSketch) add(key string) {
+	hash := hashString(key)
+	for i := range s.rows {
+		if c := &s.rows[i][s.index(hash, i)]; *c < 15 {
+			*c += 1
+		}
+	}
+	s.additions += 1
+	if s.additions == s.period {
+		for i := range s.rows {
+			for j := range s.rows[i] {
+				s.rows[i][j] >>= 1
+			}
+		}
+		s.additions /= 2
+	}
+}
+
+// Recent accesses of the key, possibly overestimated. O(1)
+func (s *freqSketch) estimate(key string) uint8 {
+	hash := hashString(key)
+	n := uint8(15)
+	for i := range s.rows {
+		n = min(n, s.rows[i][s.index(hash, i)])
+	}
+	return n
+}
+
+// May the new key replace the victim? Ties go to the new key, so
+// that without any lookups the cache is plain LRU.
+func (b *LRUCache) admit(key string, victim *entry) bool {
+	if b.sketch.estimate(key) >= b.sketch.estimate(victim.key) {
+		return true
+	}
+	b.rejections += 1
+	return false
+}
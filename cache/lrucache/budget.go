@@ -0,0 +1,64 @@
+package lrucache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Limit of the total size of items, in bytes or any other unit,
+// shared by all the caches using it, see WithSharedBudget.
+type Budget struct {
+	limit int64
+	used  atomic.Int64
+}
+
+func NewBudget(limit int64) *Budget {
+	return &Budget{limit: limit}
+}
+
+// Total size of the items in all the caches using the budget.
+func (g *Budget) Used() int64 {
+	return g.used.Load()
+}
+
+func (g *Budget) Limit() int64 {
+	return g.limit
+}
+
+// Keep the total size of items, as given by `size`, of all the caches
+// sharing `budget` within its limit, on top of the capacity of every
+// cache. When a Set takes the total over the limit, the cache doing
+// the Set evicts its least recently used items until the total is
+// within the limit again, or until only the new item is left. Caches
+// that are written a lot can grow at the expense of the quiet ones,
+// so given to a MultiLRUCache, which applies the option to every
+// bucket, memory goes to the buckets actually used instead of being
+// split statically. Items in quiet caches are only evicted by their
+// own Sets or expiry. `size` is called with the lock held and must not
+// use the cache, lazy values that were never read are passed as nil.
+func WithSharedBudget(budget *Budget, size func(key string, value interface{}) int) Option {
+	return func(b *LRUCache) {
+		b.budget = budget
+		b.budgetSize = size
+	}
+}
+
+// Size of a new item, for the budget.
+func (b *LRUCache) itemSize(key string, value interface{}) int {
+	size := 0
+	b.protect("SharedBudget", func() { size = b.budgetSize(key, peek(value)) })
+	return size
+}
+
+// Evict least recently used items other than `keep` while the budget
+// is exceeded.
+func (b *LRUCache) enforceBudget(keep *entry, now time.Time) {
+	el := b.lruList.Back()
+	for b.budget.used.Load() > b.budget.limit && el != nil {
+		e := el.Value.(*entry)
+		el = el.Prev()
+		if e != keep && b.evictable(e) {
+			b.evictEntry(e, EvictCapacity, now)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package lrucache
+
+import (
+	"fmt"
+)
+
+// A user callback panicked. The panic was recovered and the cache
+// carried on, see WithCallbackErrorHandler.
+type CallbackPanic struct {
+	Callback string
+	Value    interface{} // as passed to panic
+}
+
+func (p *CallbackPanic) Error() string {
+	return fmt.Sprintf("lrucache: %s panicked: %v", p.Callback, p.Value)
+}
+
+// Call `onEvict` for every item removed from the cache: evicted to
+// make room, expired, deleted or cleared. Not called for items that
+// are overwritten. Lazy values that were never read are passed as
+// nil. The function is called with the lock held and must not use the
+// cache.
+func WithOnEvict(onEvict func(key string, value interface{}, reason EvictReason)) Option {
+	return func(b *LRUCache) {
+		b.onEvict = onEvict
+	}
+}
+
+// Panics in user callbacks (CanEvict, OnEvict, the loader, SetLazy
+// init functions, DelIf predicates, write-through and write-behind
+// functions, the fullness callback) are always recovered, so that the
+// cache stays consistent and its lock is released. Pass them to
+// `handler` as *CallbackPanic, for example to log them. The handler
+// may be called with the lock held and must not use the cache.
+//
+// A panicking CanEvict vetoes the eviction, a panicking DelIf
+// predicate keeps the item, a panicking loader or write-through fails
+// the load or Set with the *CallbackPanic error and a panicking lazy
+// init leaves a nil value. The function passed to WithLock is not a
+// callback: its panics propagate, with the lock released.
+func WithCallbackErrorHandler(handler func(error)) Option {
+	return func(b *LRUCache) {
+		b.onCallbackError = handler
+	}
+}
+
+// Run the user callback `fn` recovering a panic. Returns the
+// *CallbackPanic error if it panicked.
+func (b *LRUCache) protect(callback string, fn func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &CallbackPanic{Callback: callback, Value: r}
+			if b.onCallbackError != nil {
+				b.onCallbackError(err)
+			}
+		}
+	}()
+	fn()
+	return nil
+}
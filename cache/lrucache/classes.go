@@ -0,0 +1,103 @@
+package lrucache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Returned by SetClass for a class the cache wasn't created with.
+var ErrNoSuchClass = errors.New("lrucache: no such expiry class")
+
+// Split the items in expiry classes, up to 256, one for every
+// interval, each with its own expiry queue and a janitor running
+// ExpireClass every `intervals[i]` for class i, or none if the
+// interval is zero. Items with TTLs of very different scales can be
+// expired at matching rates: a short interval for short lived items
+// doesn't walk the long lived ones, a long one for long lived items
+// doesn't let short lived ones linger. Items are put in a class with
+// SetClass, everything else adds to class 0 and keeps the class of an
+// item it overwrites. Expire and the eviction of expired items to make
+// room cover all the classes. Every class reserves its queue on
+// creation. The janitors live until Close is called.
+func WithExpiryClasses(intervals ...time.Duration) Option {
+	return func(b *LRUCache) {
+		if len(intervals) > 256 {
+			intervals = intervals[:256]
+		}
+		b.queues = make([]PriorityQueue, len(intervals))
+		b.classJanitors = make([]*janitor, len(intervals))
+		for i, interval := range intervals {
+			if interval > 0 {
+				b.classJanitors[i] = &janitor{
+					interval: interval,
+					class:    i,
+					stop:     make(chan struct{}),
+					done:     make(chan struct{}),
+				}
+			}
+		}
+	}
+}
+
+// Expiry class of the entry.
+func (b *LRUCache) classOf(e *entry) int {
+	if b.entryClasses == nil {
+		return 0
+	}
+	return int(b.entryClasses[e.no])
+}
+
+func (b *LRUCache) setClassOf(e *entry, class int) {
+	if b.entryClasses != nil {
+		b.entryClasses[e.no] = uint8(class)
+	}
+}
+
+// Set putting the item in expiry `class`, see WithExpiryClasses. Fails
+// with ErrNoSuchClass if the cache has no such class. O(log(n)) if
+// expiry is set, O(1) when clear.
+func (b *LRUCache) SetClass(key string, value interface{}, expire time.Time, class int) error {
+	if class < 0 || class >= len(b.queues) {
+		return ErrNoSuchClass
+	}
+	if b.writeThrough != nil {
+		if err := b.store(context.Background(), key, value, expire); err != nil {
+			return err
+		}
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err := b.waitUnfrozenOrFail(); err != nil {
+		return err
+	}
+
+	return b.setClass(key, value, expire, time.Time{}, class)
+}
+
+// Evict the items of expiry `class` that expire before `now`. Returns
+// the number of items evicted, zero for a class the cache doesn't
+// have. O(k*log(n)) for k expired items.
+func (b *LRUCache) ExpireClassNow(class int, now time.Time) int {
+	if class < 0 || class >= len(b.queues) {
+		return 0
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.waitUnfrozen()
+
+	return b.expireClass(class, now)
+}
+
+// ExpireClassNow without locking.
+func (b *LRUCache) expireClass(class int, now time.Time) int {
+	q := &b.queues[class]
+	i := 0
+	for len(*q) > 0 && b.isExpired((*q)[0], now) {
+		b.evictEntry((*q)[0], EvictExpired, now)
+		i += 1
+	}
+	return i
+}
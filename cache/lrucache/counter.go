@@ -0,0 +1,65 @@
+package lrucache
+
+import (
+	"math"
+	"time"
+)
+
+// Add `delta` to the int64 counter stored under the key and return the
+// new value. A missing or stale key, or one holding something else,
+// starts from zero and gets `expire`; existing counters keep their
+// expiry. Saturates instead of overflowing. If there's no space the
+// counter is not stored, but the value is returned all the same.
+// O(log(n)) if expiry is set, O(1) when clear.
+func (b *LRUCache) IncrementInt64(key string, delta int64, expire time.Time) int64 {
+	n, _ := b.increment(key, delta, math.MaxInt64, expire)
+	return n
+}
+
+// IncrementInt64 clamping the stored value at `max`. Reports whether
+// the cap was hit, which makes an "allow up to max per window" limiter
+// with `expire` as the end of the window.
+func (b *LRUCache) IncrementCapped(key string, delta, max int64, expire time.Time) (n int64, hitCap bool) {
+	return b.increment(key, delta, max, expire)
+}
+
+func (b *LRUCache) increment(key string, delta, max int64, expire time.Time) (int64, bool) {
+	now := time.Now()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.waitUnfrozen()
+
+	var n int64
+	var counter *entry
+	if e := b.lookup(key); e != nil && !b.isExpired(e, now) {
+		if v, ok := e.value.(int64); ok {
+			n, counter = v, e
+		}
+	}
+
+	sum, capped := n+delta, false
+	switch {
+	case sum > max || (delta > 0 && sum < n):
+		sum, capped = max, true
+	case delta < 0 && sum > n:
+		sum = math.MinInt64
+	}
+	if counter == nil {
+		b.set(key, sum, expire, now)
+		return sum, capped
+	}
+
+	// Updated in place, the stored expiry isn't adjusted again.
+	b.touchEntry(counter)
+	counter.value = sum
+	if b.budget != nil {
+		size := b.itemSize(key, sum)
+		b.budget.used.Add(int64(size - b.entrySizes[counter.no]))
+		b.entrySizes[counter.no] = size
+		b.enforceBudget(counter, now)
+	}
+	if b.writeBehind != nil {
+		b.writeBehind.add(key, sum)
+	}
+	return sum, capped
+}
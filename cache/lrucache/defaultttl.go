@@ -0,0 +1,33 @@
+package lrucache
+
+import (
+	"time"
+)
+
+// Set the TTL used by SetDefault. Zero means no expiry. Only affects
+// items set later on. Safe to call concurrently with other methods.
+func (b *LRUCache) SetDefaultTTL(ttl time.Duration) {
+	b.defaultTTL.Store(int64(ttl))
+}
+
+// The TTL used by SetDefault.
+func (b *LRUCache) DefaultTTL() time.Duration {
+	return time.Duration(b.defaultTTL.Load())
+}
+
+// Add an item expiring after the current default TTL, or never if
+// it's zero.
+func (b *LRUCache) SetDefault(key string, value interface{}) error {
+	var expire time.Time
+	if ttl := b.DefaultTTL(); ttl > 0 {
+		expire = time.Now().Add(ttl)
+	}
+	return b.Set(key, value, expire)
+}
+
+// Initial TTL for SetDefault, see SetDefaultTTL.
+func WithDefaultTTL(ttl time.Duration) Option {
+	return func(b *LRUCache) {
+		b.SetDefaultTTL(ttl)
+	}
+}
@@ -0,0 +1,69 @@
+package lrucache
+
+import (
+	"time"
+)
+
+// Instead of dropping an item pushed out to make room, let `demote`
+// replace it with a cheaper one, for example a full value with a
+// compact summary, kept in the cache as a lower tier. If `demote`
+// returns ok the item is rekeyed to `newKey` in place, takes
+// `newValue` and `expire`, and goes to the front of the LRU list, or
+// of the probationary segment with WithSLRU. Set demotes at most one
+// item, the next least used item is evicted to make room, so a Set
+// costs at most one demotion and the cache doesn't end up all lower
+// tier items. An item is demoted at most `maxDepth` times, then it's
+// evicted normally. Demoting to a key that is already in the cache evicts the
+// item instead. Items that expired, are deleted or cleared are never
+// demoted. `demote` is called with the lock held and must not use the
+// cache, lazy values that were never read are passed as nil.
+func WithDemotion(maxDepth int, demote func(key string, value interface{}) (newKey string, newValue interface{}, expire time.Time, ok bool)) Option {
+	return func(b *LRUCache) {
+		if maxDepth > 255 {
+			maxDepth = 255
+		}
+		b.maxDemotions = maxDepth
+		b.demote = demote
+	}
+}
+
+// Demote the entry chosen to be evicted. Returns false if it must be
+// evicted after all.
+func (b *LRUCache) demoteEntry(e *entry) bool {
+	if int(b.entryDemotions[e.no]) >= b.maxDemotions {
+		return false
+	}
+	var newKey string
+	var newValue interface{}
+	var expire time.Time
+	ok := false
+	err := b.protect("Demote", func() { newKey, newValue, expire, ok = b.demote(e.key, peek(e.value)) })
+	if err != nil || !ok || (newKey != e.key && b.lookup(newKey) != nil) {
+		return false
+	}
+	if b.slotSize > 0 {
+		v, err := b.slotValue(newValue)
+		if err != nil {
+			return false
+		}
+		newValue = b.fillSlot(e, v)
+	}
+
+	b.rename(e.key, newKey)
+	if b.budget != nil {
+		size := b.itemSize(newKey, newValue)
+		b.budget.used.Add(int64(size - b.entrySizes[e.no]))
+		b.entrySizes[e.no] = size
+	}
+	e.value = newValue
+	b.entryDemotions[e.no] += 1
+	b.demotions += 1
+	b.setExpire(e, b.adjustExpire(expire))
+	if b.slru != nil {
+		b.slru.requeue(b, e)
+	} else {
+		b.lruList.Remove(&e.element)
+		b.lruList.PushElementFront(&e.element)
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+package lrucache
+
+// Counters of one diagnostic structure: the miss log, the ghost list
+// or the eviction log.
+type DiagnosticStats struct {
+	Overwritten uint64 // records pushed out by newer ones
+	Dropped     uint64 // keys longer than the bound, never recorded
+}
+
+// Did the structure lose anything it was given?
+func (s DiagnosticStats) Truncated() bool {
+	return s.Overwritten > 0 || s.Dropped > 0
+}
+
+// Counters of all the diagnostic structures, zero for those not used.
+type Diagnostics struct {
+	Misses    DiagnosticStats // see WithMissTracking
+	Ghost     DiagnosticStats // see WithGhostList
+	Evictions DiagnosticStats // see WithEvictionLog
+}
+
+func (d Diagnostics) Truncated() bool {
+	return d.Misses.Truncated() || d.Ghost.Truncated() || d.Evictions.Truncated()
+}
+
+// Bound and counters shared by the diagnostic structures, guarded
+// like the structure.
+type diagBound struct {
+	maxKey      int // longest key recorded, no limit if 0
+	overwritten uint64
+	dropped     uint64
+}
+
+// Is the key short enough to be recorded? Counts it if not.
+func (d *diagBound) fits(key string) bool {
+	if d.maxKey > 0 && len(key) > d.maxKey {
+		d.dropped += 1
+		return false
+	}
+	return true
+}
+
+func (d *diagBound) stats() DiagnosticStats {
+	return DiagnosticStats{Overwritten: d.overwritten, Dropped: d.dropped}
+}
+
+// Bound the memory of the miss log, the ghost list and the eviction
+// log, whatever keys they are fed: keep at most `maxRecords` records
+// in each, lowering the sizes they were given, and don't record keys
+// longer than `maxKeyLen` bytes. Either is unlimited if 0. The
+// structures count what they lose, see Diagnostics, so a flood of
+// hostile keys shows up instead of growing them.
+func WithDiagnosticsBound(maxRecords, maxKeyLen int) Option {
+	return func(b *LRUCache) {
+		b.diagRecords = maxRecords
+		b.diagKeyLen = maxKeyLen
+	}
+}
+
+// Apply WithDiagnosticsBound, once all the options are known.
+func (b *LRUCache) boundDiagnostics() {
+	n := b.diagRecords
+	if l := b.missLog; l != nil {
+		if n > 0 && len(l.keys) > n {
+			l.keys = make([]string, n)
+		}
+		l.maxKey = b.diagKeyLen
+	}
+	if g := b.ghost; g != nil {
+		if n > 0 && len(g.keys) > n {
+			g.keys = make([]string, n)
+		}
+		g.maxKey = b.diagKeyLen
+	}
+	if l := b.evictionLog; l != nil {
+		if n > 0 && len(l.records) > n {
+			l.records = make([]EvictionRecord, n)
+		}
+		l.maxKey = b.diagKeyLen
+	}
+}
+
+// Counters of the diagnostic structures. O(1)
+func (b *LRUCache) Diagnostics() Diagnostics {
+	var d Diagnostics
+	if l := b.missLog; l != nil {
+		l.lock.Lock()
+		d.Misses = l.stats()
+		l.lock.Unlock()
+	}
+	if l := b.evictionLog; l != nil {
+		l.lock.Lock()
+		d.Evictions = l.stats()
+		l.lock.Unlock()
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.ghost != nil {
+		d.Ghost = b.ghost.stats()
+	}
+	return d
+}
@@ -0,0 +1,48 @@
+package lrucache
+
+import (
+	"errors"
+)
+
+// Returned by Set and friends once Drain was called.
+var ErrDraining = errors.New("lrucache: cache is draining")
+
+// Stop accepting new items, for rolling a cache over without dropping
+// reads in flight. Set and the other calls adding items fail with
+// ErrDraining, or return their failure value if they can't report an
+// error, while reads, Del and expiry work as usual, so the cache
+// empties as its items expire. The returned channel is closed once the
+// cache is empty, after which it may be discarded. Unlike Freeze
+// nothing blocks, and there is no way back. Idempotent.
+func (b *LRUCache) Drain() <-chan struct{} {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if !b.draining.Load() {
+		b.draining.Store(true)
+		b.drained = make(chan struct{})
+		b.checkDrained()
+	}
+	return b.drained
+}
+
+// Was Drain called and is the cache empty now?
+func (b *LRUCache) Drained() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.draining.Load() && b.lruList.Len() == 0
+}
+
+// Signal the Drain channel if the cache just became empty. Called with
+// the lock held.
+func (b *LRUCache) checkDrained() {
+	if b.lruList.Len() > 0 {
+		return
+	}
+	select {
+	case <-b.drained:
+	default:
+		close(b.drained)
+	}
+}
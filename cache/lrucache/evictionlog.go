@@ -0,0 +1,108 @@
+package lrucache
+
+import (
+	"sync"
+	"time"
+)
+
+// Reason why an entry was removed from the cache.
+type EvictReason int
+
+const (
+	EvictDeleted  EvictReason = iota // removed with Del
+	EvictCapacity                    // least used entry pushed out to make room
+	EvictExpired                     // expired, removed by Expire, the janitor or to make room
+	EvictCleared                     // removed by Clear
+	EvictStale                       // expired, found stale on lookup
+
+	evictReasons = iota // number of reasons
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictDeleted:
+		return "deleted"
+	case EvictCapacity:
+		return "capacity"
+	case EvictExpired:
+		return "expired"
+	case EvictCleared:
+		return "cleared"
+	case EvictStale:
+		return "stale"
+	}
+	return "unknown"
+}
+
+// A single entry of the eviction log.
+type EvictionRecord struct {
+	Key    string
+	Reason EvictReason
+	Time   time.Time
+}
+
+// Fixed size ring buffer of the most recent evictions. It has its
+// own lock so that dumping it doesn't stall the cache.
+type evictionLog struct {
+	lock    sync.Mutex
+	records []EvictionRecord
+	next    int // slot to be overwritten by the next record
+	full    bool
+	diagBound
+}
+
+func newEvictionLog(size int) *evictionLog {
+	return &evictionLog{records: make([]EvictionRecord, size)}
+}
+
+func (l *evictionLog) add(r EvictionRecord) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if !l.fits(r.Key) {
+		return
+	}
+	if l.full {
+		l.overwritten += 1
+	}
+	l.records[l.next] = r
+	l.next += 1
+	if l.next == len(l.records) {
+		l.next = 0
+		l.full = true
+	}
+}
+
+// Copy of the log, oldest record first.
+func (l *evictionLog) snapshot() []EvictionRecord {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if !l.full {
+		return append([]EvictionRecord(nil), l.records[:l.next]...)
+	}
+	r := make([]EvictionRecord, 0, len(l.records))
+	r = append(r, l.records[l.next:]...)
+	return append(r, l.records[:l.next]...)
+}
+
+// Remember the last `size` evictions, queryable with
+// RecentEvictions. Replacing a value with Set is not an eviction.
+// The log is allocated once, recording an eviction costs one
+// time.Now() call and a short critical section.
+func WithEvictionLog(size int) Option {
+	return func(b *LRUCache) {
+		if size > 0 {
+			b.evictionLog = newEvictionLog(size)
+		}
+	}
+}
+
+// Recent evictions, oldest first. Empty unless the cache was created
+// with WithEvictionLog.
+func (b *LRUCache) RecentEvictions() []EvictionRecord {
+	if b.evictionLog == nil {
+		return nil
+	}
+	return b.evictionLog.snapshot()
+}
@@ -0,0 +1,51 @@
+package lrucache
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Line written by ExportNDJSON.
+type exportedEntry struct {
+	Key    string      `json:"key"`
+	Value  interface{} `json:"value"`
+	Expire *time.Time  `json:"expire,omitempty"`
+}
+
+// Write the items to `w` as newline delimited JSON, one object with
+// "key", "value" and "expire" per line, most recently used first.
+// Items without expiry have no "expire". Like Iterator, the keys are
+// snapshotted first and then every item is fetched and written to `w`
+// on its own, so the lock isn't held for the whole dump and values
+// aren't buffered. Items evicted in the meantime are skipped. O(n)
+func (b *LRUCache) ExportNDJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, key := range b.Keys() {
+		value, expire, ok := b.getWithExpire(key)
+		if !ok {
+			continue
+		}
+		item := exportedEntry{Key: key, Value: value}
+		if !expire.IsZero() {
+			item.Expire = &expire
+		}
+		if err := enc.Encode(&item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Like GetQuiet, also returning the expiry.
+func (b *LRUCache) getWithExpire(key string) (value interface{}, expire time.Time, ok bool) {
+	defer b.materialize(key, &value)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	e := b.lookup(key)
+	if e == nil {
+		return nil, time.Time{}, false
+	}
+	return e.value, e.expire, true
+}
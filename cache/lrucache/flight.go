@@ -0,0 +1,76 @@
+package lrucache
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrTooManyInflight = errors.New("lrucache: too many loads in flight")
+
+// Load in progress for a single key.
+type call struct {
+	done  chan struct{} // closed when value and err are set
+	value interface{}
+	err   error
+}
+
+// Makes sure only one load per key runs at a time, concurrent callers
+// share its result.
+type flight struct {
+	lock  sync.Mutex
+	calls map[string]*call
+	slots chan struct{} // one per running fn, nil if unbounded
+	fail  bool          // fail instead of waiting for a slot
+}
+
+// Start running `fn` for the key in the background, unless it's
+// already running. Returns the call to wait on.
+func (f *flight) start(key string, fn func() (interface{}, error)) *call {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if c, ok := f.calls[key]; ok {
+		return c
+	}
+	if f.calls == nil {
+		f.calls = make(map[string]*call)
+	}
+
+	c := &call{done: make(chan struct{})}
+	acquired := false
+	if f.slots != nil && f.fail {
+		select {
+		case f.slots <- struct{}{}:
+			acquired = true
+		default:
+			c.err = ErrTooManyInflight
+			close(c.done)
+			return c
+		}
+	}
+	f.calls[key] = c
+	go func() {
+		if f.slots != nil {
+			if !acquired {
+				f.slots <- struct{}{}
+			}
+			c.value, c.err = fn()
+			<-f.slots
+		} else {
+			c.value, c.err = fn()
+		}
+		f.lock.Lock()
+		delete(f.calls, key)
+		f.lock.Unlock()
+		close(c.done)
+	}()
+	return c
+}
+
+// Run `fn` for the key, or join the run already in progress, and wait
+// for the result.
+func (f *flight) do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	c := f.start(key, fn)
+	<-c.done
+	return c.value, c.err
+}
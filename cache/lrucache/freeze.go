@@ -0,0 +1,83 @@
+package lrucache
+
+import (
+	"errors"
+	"time"
+)
+
+// Returned by Set and friends while frozen, see WithFreezeErrors.
+var ErrFrozen = errors.New("lrucache: cache is frozen")
+
+// Temporarily make the cache read-only, for example to take a
+// consistent look at many keys. While frozen, Set, Del, Clear, Expire
+// and other modifying calls block until Unfreeze. With
+// WithFreezeErrors the calls returning an error fail with ErrFrozen
+// instead, the others like Del and Clear block all the same: they
+// have no way of reporting an error, and silently dropping a write
+// would be worse. Reads work as usual, including updating the LRU
+// order, but GetNotStale reports a stale item as missing without
+// evicting it. Must not be called twice without Unfreeze in between
+// by independent users: there is no nesting.
+func (b *LRUCache) Freeze() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.frozen = true
+}
+
+// Let the modifying calls blocked by Freeze continue.
+func (b *LRUCache) Unfreeze() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.frozen = false
+	b.unfrozen.Broadcast()
+}
+
+// Is the cache frozen at the moment?
+func (b *LRUCache) Frozen() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.frozen
+}
+
+// Must be called with the lock held, before modifying anything.
+func (b *LRUCache) waitUnfrozen() {
+	for b.frozen {
+		b.unfrozen.Wait()
+	}
+}
+
+// Make Set, SetNow, SetCtx, SetDefault, SetClass and WarmFrom fail
+// with ErrFrozen while the cache is frozen, instead of blocking until
+// Unfreeze. Nothing is written through, see WithWriteThrough. The
+// other modifying calls block as usual.
+func WithFreezeErrors() Option {
+	return func(b *LRUCache) {
+		b.failFrozen = true
+	}
+}
+
+// Like waitUnfrozen, for the calls that report errors.
+func (b *LRUCache) waitUnfrozenOrFail() error {
+	if b.frozen && b.failFrozen {
+		return ErrFrozen
+	}
+	b.waitUnfrozen()
+	return nil
+}
+
+// For the janitor: blocking in a frozen cache would make Close hang.
+func (b *LRUCache) expireUnlessFrozen(class int) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.frozen {
+		return 0
+	}
+	if class >= 0 {
+		return b.expireClass(class, time.Now())
+	}
+	return b.expire(time.Now())
+}
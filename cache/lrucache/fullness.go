@@ -0,0 +1,56 @@
+package lrucache
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Fullness of the cache and the callback told about it, see
+// WithFullnessCallback.
+type fullness struct {
+	callback func(full bool)
+	full     atomic.Bool // current state, written with the cache lock held
+	pending  atomic.Bool // a notify goroutine is about to run
+
+	lock     sync.Mutex // serializes the callbacks
+	reported bool       // state last passed to the callback
+}
+
+// Call `callback` when the cache becomes full, its last free slot
+// taken, and when it has a free slot again. Only the transitions are
+// reported, and only the latest state: if the cache goes full and back
+// before the callback runs, nothing is reported. The callback runs in
+// its own goroutine without the cache lock held, so it may use the
+// cache, and calls never overlap. An empty cache is not full to begin
+// with. With MultiLRUCache every bucket reports on its own.
+func WithFullnessCallback(callback func(full bool)) Option {
+	return func(b *LRUCache) {
+		b.fullness = &fullness{callback: callback}
+	}
+}
+
+// Record the fullness after the LRU list changed. Called with the lock
+// held.
+func (f *fullness) update(b *LRUCache) {
+	full := b.freeList.Len() == 0
+	if f.full.Load() == full {
+		return
+	}
+	f.full.Store(full)
+	if f.pending.CompareAndSwap(false, true) {
+		go f.notify(b)
+	}
+}
+
+func (f *fullness) notify(b *LRUCache) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.pending.Store(false)
+	full := f.full.Load()
+	if full == f.reported {
+		return
+	}
+	f.reported = full
+	b.protect("FullnessCallback", func() { f.callback(full) })
+}
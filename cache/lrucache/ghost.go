@@ -0,0 +1,76 @@
+package lrucache
+
+// Keys of the items most recently pushed out to make room, without
+// their values, see WithGhostList. Guarded by the cache lock.
+type ghostList struct {
+	keys  []string
+	next  int            // slot to be overwritten by the next key
+	count map[string]int // occurrences of every key in keys
+	full  bool
+
+	misses uint64 // of Get and GetNotStale
+	hits   uint64 // misses of keys in the list
+	diagBound
+}
+
+func (g *ghostList) add(key string) {
+	if !g.fits(key) {
+		return
+	}
+	if g.full {
+		g.overwritten += 1
+		old := g.keys[g.next]
+		if g.count[old] -= 1; g.count[old] == 0 {
+			delete(g.count, old)
+		}
+	}
+	g.keys[g.next] = key
+	g.count[key] += 1
+	g.next += 1
+	if g.next == len(g.keys) {
+		g.next = 0
+		g.full = true
+	}
+}
+
+func (g *ghostList) miss(key string) {
+	g.misses += 1
+	if g.count[key] > 0 {
+		g.hits += 1
+	}
+}
+
+// Remember the keys of the last `size` items evicted to make room,
+// and count the misses of Get and GetNotStale on them, see
+// GhostHitRate. Costs a map entry per remembered key.
+func WithGhostList(size int) Option {
+	return func(b *LRUCache) {
+		if size > 0 {
+			b.ghost = &ghostList{keys: make([]string, size), count: make(map[string]int)}
+		}
+	}
+}
+
+// Fraction of the misses of Get and GetNotStale on keys that were
+// recently evicted to make room. A high rate means the cache is too
+// small: a larger one would have kept those items. Zero unless the
+// cache was created with WithGhostList or when there were no misses.
+func (b *LRUCache) GhostHitRate() float64 {
+	hits, misses := b.GhostHits()
+	if misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(misses)
+}
+
+// The counts behind GhostHitRate: misses on recently evicted keys and
+// all the misses.
+func (b *LRUCache) GhostHits() (hits, misses uint64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.ghost == nil {
+		return 0, 0
+	}
+	return b.ghost.hits, b.ghost.misses
+}
@@ -0,0 +1,139 @@
+package lrucache
+
+import (
+	"container/heap"
+	"time"
+)
+
+// An item pinned by Acquire. Not safe for concurrent use.
+type EntryHandle struct {
+	b        *LRUCache
+	e        *entry
+	gen      uint64
+	value    interface{}
+	expire   time.Time
+	dirty    bool
+	released bool
+}
+
+// An entry as its handles know it, see EntryHandle.
+type handleRef struct {
+	e   *entry
+	gen uint64
+}
+
+// Where the handles of a pinned entry moved to another cache go.
+type forward struct {
+	dst  *LRUCache
+	to   handleRef
+	pins int32 // handles not released yet
+}
+
+// Get a key and pin it: until the handle is released the item is not
+// evicted to make room, nor because it expired. Del, Clear and Set of
+// the key still remove it, the changes made through the handle are
+// then lost on Release. Changes are applied on Release. Updates the
+// LRU score, lazy values are computed without the lock held. Pinned
+// items are kept out of the expiry queue, so PriorityQueueStats,
+// ExpiredKeys and TTLHistogram don't see their expiry. Every Acquire
+// must be followed by Release, a handle that is never released pins
+// its item for good. O(log(n))
+func (b *LRUCache) Acquire(key string) (*EntryHandle, bool) {
+	e := b.lockResolved(key)
+	defer b.lock.Unlock()
+
+	if e == nil {
+		return nil, false
+	}
+	b.touchEntry(e)
+	b.pin(e, 1)
+	return &EntryHandle{b: b, e: e, gen: e.gen, value: e.value, expire: e.expire}, true
+}
+
+// Add pins to the entry, taking it out of the expiry queue.
+func (b *LRUCache) pin(e *entry, pins int32) {
+	if e.pins == 0 && e.index != -1 {
+		heap.Remove(b.queue(e), e.index)
+	}
+	e.pins += pins
+}
+
+func (h *EntryHandle) check() {
+	if h.released {
+		panic("lrucache: EntryHandle used after Release")
+	}
+}
+
+// The value as of Acquire, or as set with SetValue.
+func (h *EntryHandle) Value() interface{} {
+	h.check()
+	return h.value
+}
+
+// Replace the value on Release. Value must not be nil.
+func (h *EntryHandle) SetValue(value interface{}) {
+	h.check()
+	h.value, h.dirty = value, true
+}
+
+// Replace the expiry on Release.
+func (h *EntryHandle) SetExpire(expire time.Time) {
+	h.check()
+	h.expire, h.dirty = expire, true
+}
+
+// Apply the changes and unpin the item. The handle can't be used
+// afterwards. O(log(n))
+func (h *EntryHandle) Release() {
+	h.check()
+	h.released = true
+	for h.release() {
+	}
+}
+
+// Release in the cache of the handle. Returns true if the item was
+// moved to another cache, the handle then points to it there.
+func (h *EntryHandle) release() (moved bool) {
+	b, e := h.b, h.e
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.waitUnfrozen()
+
+	if e.gen != h.gen {
+		// Removed in the meantime, or moved by MoveTo.
+		ref := handleRef{e, h.gen}
+		f := b.forwards[ref]
+		if f == nil {
+			return false
+		}
+		f.pins -= 1
+		if f.pins == 0 {
+			delete(b.forwards, ref)
+		}
+		h.b, h.e, h.gen = f.dst, f.to.e, f.to.gen
+		return true
+	}
+	if h.dirty && b.slotSize > 0 {
+		if v, err := b.slotValue(h.value); err != nil {
+			h.value = e.value
+		} else {
+			h.value = b.fillSlot(e, v)
+		}
+	}
+	if h.dirty {
+		if b.budget != nil {
+			size := b.itemSize(e.key, h.value)
+			b.budget.used.Add(int64(size - b.entrySizes[e.no]))
+			b.entrySizes[e.no] = size
+		}
+		e.value, e.expire = h.value, h.expire
+		if b.writeBehind != nil {
+			b.writeBehind.add(e.key, e.value)
+		}
+	}
+	e.pins -= 1
+	if e.pins == 0 && !e.expire.IsZero() {
+		heap.Push(b.queue(e), e)
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package lrucache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Sub-intervals a window is split into, see WithHitRateWindow.
+const hitWindowSlots = 10
+
+// Ring of hit and miss counts per sub-interval. Updated with the
+// cache lock held, read without it.
+type hitWindow struct {
+	interval time.Duration
+	ring     [hitWindowSlots]struct {
+		epoch  atomic.Int64 // sub-interval number counted in the slot
+		hits   atomic.Uint64
+		misses atomic.Uint64
+	}
+}
+
+func (w *hitWindow) record(hit bool, now time.Time) {
+	epoch := now.UnixNano() / int64(w.interval)
+	s := &w.ring[epoch%hitWindowSlots]
+	if old := s.epoch.Load(); old != epoch && s.epoch.CompareAndSwap(old, epoch) {
+		s.hits.Store(0)
+		s.misses.Store(0)
+	}
+	if hit {
+		s.hits.Add(1)
+	} else {
+		s.misses.Add(1)
+	}
+}
+
+// Count the hits and misses of Get and GetNotStale over the last
+// `window`, for WindowedHitRate, in ten sub-intervals of window/10.
+// Subject to WithStatsSampling.
+func WithHitRateWindow(window time.Duration) Option {
+	return func(b *LRUCache) {
+		if window >= hitWindowSlots {
+			b.hitWindow = &hitWindow{interval: window / hitWindowSlots}
+		}
+	}
+}
+
+func (b *LRUCache) hit() {
+	b.countLookup(true)
+}
+
+// Fraction of Get and GetNotStale calls that were hits over the last
+// `window`, up to the one given to WithHitRateWindow. Approximate:
+// whole sub-intervals are counted, so the rate covers up to one
+// sub-interval less than `window`, and counts racing with the start of
+// a sub-interval may be lost. Unlike counts since creation it shows a
+// recent degradation. Zero without WithHitRateWindow or when there
+// were no calls. O(1)
+func (b *LRUCache) WindowedHitRate(window time.Duration) float64 {
+	hits, misses := b.WindowedHits(window)
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+// The counts behind WindowedHitRate.
+func (b *LRUCache) WindowedHits(window time.Duration) (hits, misses uint64) {
+	w := b.hitWindow
+	if w == nil {
+		return 0, 0
+	}
+	n := int64((window + w.interval - 1) / w.interval)
+	if n > hitWindowSlots {
+		n = hitWindowSlots
+	}
+	cur := time.Now().UnixNano() / int64(w.interval)
+	for i := range w.ring {
+		s := &w.ring[i]
+		if epoch := s.epoch.Load(); epoch > cur-n && epoch <= cur {
+			hits += s.hits.Load()
+			misses += s.misses.Load()
+		}
+	}
+	return hits * b.sampleRate, misses * b.sampleRate
+}
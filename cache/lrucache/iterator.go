@@ -0,0 +1,97 @@
+package lrucache
+
+import (
+	"time"
+)
+
+// Pull style iterator over the items of a cache, see Iterator.
+type EntryIterator struct {
+	keys  []string
+	get   func(key string) (interface{}, bool)
+	key   string
+	value interface{}
+}
+
+// Iterator over `keys`, fetching values with `get` as it goes. Keys
+// that `get` doesn't find are skipped. Mostly useful for building
+// iterators over other caches, like multilru.
+func NewEntryIterator(keys []string, get func(key string) (interface{}, bool)) *EntryIterator {
+	return &EntryIterator{keys: keys, get: get}
+}
+
+// Advance to the next item. Returns false when there are no more.
+func (it *EntryIterator) Next() bool {
+	for len(it.keys) > 0 {
+		key := it.keys[0]
+		it.keys = it.keys[1:]
+		if value, ok := it.get(key); ok {
+			it.key, it.value = key, value
+			return true
+		}
+	}
+	it.key, it.value = "", nil
+	return false
+}
+
+// The item Next moved to.
+func (it *EntryIterator) Current() (key string, value interface{}) {
+	return it.key, it.value
+}
+
+// Iterate over the items, most recently used first:
+//
+//	for it := b.Iterator(); it.Next(); {
+//		key, value := it.Current()
+//	}
+//
+// The keys are snapshotted when the iterator is created, values are
+// fetched one by one as with GetQuiet. Items added later are not
+// visited, items evicted in the meantime are skipped and values may
+// have changed since the snapshot. No lock is held between calls, so
+// the cache may be freely used while iterating.
+func (b *LRUCache) Iterator() *EntryIterator {
+	return NewEntryIterator(b.Keys(), b.GetQuiet)
+}
+
+// Keys of all the items, most recently used first. O(n)
+func (b *LRUCache) Keys() []string {
+	return b.KeysInto(nil)
+}
+
+// Keys, filling `dst` instead of allocating a new slice, so that the
+// backing array can be reused from call to call. The result aliases
+// `dst` if the keys fit in its capacity, otherwise a larger slice is
+// allocated. O(n)
+func (b *LRUCache) KeysInto(dst []string) []string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	keys := dst[:0]
+	if n := b.lruList.Len(); cap(keys) < n {
+		keys = make([]string, 0, n)
+	}
+	for el := b.lruList.Front(); el != nil; el = el.Next() {
+		keys = append(keys, el.Value.(*entry).key)
+	}
+	return keys
+}
+
+// Call `fn` for every item, most recently used first, with the live
+// value and without a snapshot. The lowest overhead way to read all
+// the items, for bulk exports Iterator is too slow for.
+//
+// UNSAFE: the lock is held for the whole walk. `fn` must not use the
+// cache in any way, not even to read, or it deadlocks, must not
+// modify the value or anything it points to, and must not keep the
+// value past the call if it may change. It should be quick, as every
+// other user of the cache waits for it. Lazy values that were never
+// read are passed as nil. Expired items are included. O(n)
+func (b *LRUCache) UnsafeRange(fn func(key string, value interface{}, expire time.Time)) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for el := b.lruList.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*entry)
+		fn(e.key, peek(e.value), e.expire)
+	}
+}
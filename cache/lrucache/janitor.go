@@ -0,0 +1,71 @@
+package lrucache
+
+import (
+	"sync"
+	"time"
+)
+
+// Background goroutine periodically evicting expired items.
+type janitor struct {
+	interval time.Duration
+	class    int // expiry class to expire, all if negative
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+func (j *janitor) run(b *LRUCache) {
+	defer close(j.done)
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.expireUnlessFrozen(j.class)
+		case <-j.stop:
+			return
+		}
+	}
+}
+
+// Stop the goroutine and wait for it to exit. Safe to call many times.
+func (j *janitor) close() {
+	j.once.Do(func() {
+		close(j.stop)
+	})
+	<-j.done
+}
+
+// Run Expire every `interval` in a background goroutine. The
+// goroutine lives until Close is called.
+func WithJanitor(interval time.Duration) Option {
+	return func(b *LRUCache) {
+		if interval > 0 {
+			b.janitor = &janitor{
+				interval: interval,
+				class:    -1,
+				stop:     make(chan struct{}),
+				done:     make(chan struct{}),
+			}
+		}
+	}
+}
+
+// Stop the background goroutines started by the options, waiting for
+// them to finish. The cache itself stays usable, but expired items
+// are no longer evicted in the background and write-behind stops
+// after one last flush. Idempotent.
+func (b *LRUCache) Close() {
+	if b.janitor != nil {
+		b.janitor.close()
+	}
+	for _, j := range b.classJanitors {
+		if j != nil {
+			j.close()
+		}
+	}
+	if b.writeBehind != nil {
+		b.writeBehind.close()
+	}
+}
@@ -0,0 +1,102 @@
+package lrucache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Value computed on first access, see SetLazy.
+type lazyValue struct {
+	once  sync.Once
+	done  atomic.Bool // value is set
+	cache *LRUCache
+	init  func() interface{}
+	value interface{}
+}
+
+func (l *lazyValue) get() interface{} {
+	l.once.Do(func() {
+		l.cache.protect("SetLazy init", func() { l.value = l.init() })
+		l.init = nil
+		l.done.Store(true)
+	})
+	return l.value
+}
+
+// Add an item whose value is computed by `init` on first access. The
+// first Get runs `init` exactly once, concurrent Gets of the same key
+// wait for it, and the result replaces the function in the cache. If
+// the item is evicted before it is read, `init` never runs. `init`
+// runs without the cache lock held, so it may use the cache, except
+// when the value is first read through a Tx inside WithLock: then it
+// runs with the lock held and must not use the cache. Lazy items are
+// not passed to WithWriteThrough.
+func (b *LRUCache) SetLazy(key string, init func() interface{}, expire time.Time) error {
+	return b.insert(key, &lazyValue{cache: b, init: init}, expire, time.Time{})
+}
+
+// The real value of a possibly lazy value.
+func resolve(v interface{}) interface{} {
+	if l, ok := v.(*lazyValue); ok {
+		return l.get()
+	}
+	return v
+}
+
+// The value if it is not lazy or was already computed, nil otherwise.
+// Never runs init.
+func peek(v interface{}) interface{} {
+	if l, ok := v.(*lazyValue); ok {
+		if !l.done.Load() {
+			return nil
+		}
+		return l.value
+	}
+	return v
+}
+
+// Lock the cache for modifying the key, like Lock and waitUnfrozen,
+// with the lazy value of the key computed. `init` runs without the
+// lock held, again if the key was set to another lazy value
+// meanwhile. Returns the entry of the key, nil if it's missing.
+func (b *LRUCache) lockResolved(key string) *entry {
+	for {
+		b.lock.Lock()
+		b.waitUnfrozen()
+		e := b.lookup(key)
+		if e == nil {
+			return nil
+		}
+		l, ok := e.value.(*lazyValue)
+		if !ok {
+			return e
+		}
+		if l.done.Load() {
+			e.value = l.value
+			return e
+		}
+		b.lock.Unlock()
+		var v interface{} = l
+		b.materialize(key, &v)
+	}
+}
+
+// Turn a lazy value, freshly read from the key, into the real one.
+// Must be called without the lock: it's deferred by the getters so
+// that it runs after the deferred unlock.
+func (b *LRUCache) materialize(key string, v *interface{}) {
+	l, ok := (*v).(*lazyValue)
+	if !ok {
+		return
+	}
+	*v = l.get()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if e := b.lookup(key); e != nil {
+		if cur, ok := e.value.(*lazyValue); ok && cur == l {
+			e.value = *v
+		}
+	}
+}
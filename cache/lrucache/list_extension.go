@@ -1,19 +1,33 @@
 package lrucache
 
+// PushElementFront inserts an existing, unlinked element e at the
+// front of list l and returns e. Unlike PushFront no memory is
+// allocated.
 func (l *List) PushElementFront(e *Element) *Element {
 	return l.insert(e, &l.root)
 }
 
+// PushElementBack inserts an existing, unlinked element e at the back
+// of list l and returns e. Unlike PushBack no memory is allocated.
 func (l *List) PushElementBack(e *Element) *Element {
 	return l.insert(e, l.root.prev)
 }
 
+// InsertElementAfter inserts an existing, unlinked element e
+// immediately after mark and returns e. The mark must be an element
+// of l. Unlike InsertAfter no memory is allocated.
+func (l *List) InsertElementAfter(e, mark *Element) *Element {
+	return l.insert(e, mark)
+}
+
+// PopElementFront removes the first element of list l and returns it.
 func (l *List) PopElementFront() *Element {
 	el := l.Front()
 	l.Remove(el)
 	return el
 }
 
+// PopFront removes the first element of list l and returns its value.
 func (l *List) PopFront() interface{} {
 	el := l.Front()
 	l.Remove(el)
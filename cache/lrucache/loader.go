@@ -0,0 +1,136 @@
+package lrucache
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrNoLoader = errors.New("lrucache: no loader configured")
+
+// Function fetching the value for a key from the source of truth, see
+// WithLoader.
+type Loader func(key string) (value interface{}, expire time.Time, err error)
+
+// Register a loader used by GetWithRefresh to fill in missing or stale
+// items.
+func WithLoader(loader Loader) Option {
+	return func(b *LRUCache) {
+		b.loader = loader
+	}
+}
+
+// What GetWithRefresh does when WithMaxInflight loads are running
+// already.
+type InflightPolicy int
+
+const (
+	// Wait for one of the running loads to finish.
+	BlockWhenFull InflightPolicy = iota
+	// Fail with ErrTooManyInflight.
+	FailWhenFull
+)
+
+// Run at most `n` loads for GetWithRefresh at a time, so that a flood
+// of distinct missing keys can't start a load each. `policy` decides
+// what happens to loads over the limit. Waiting loads still merge
+// concurrent callers of their key. No limit if n <= 0.
+func WithMaxInflight(n int, policy InflightPolicy) Option {
+	return func(b *LRUCache) {
+		if n > 0 {
+			b.flight.slots = make(chan struct{}, n)
+			b.flight.fail = policy == FailWhenFull
+		}
+	}
+}
+
+// What GetWithRefresh does when it finds a stale item.
+type RefreshMode int
+
+const (
+	// Wait for the loader and return the fresh value.
+	BlockOnStale RefreshMode = iota
+	// Return the stale value straight away and reload it in the
+	// background.
+	StaleWhileRevalidate
+)
+
+// Get a key making sure it's not stale, using the loader registered
+// with WithLoader to fetch it when it's missing or stale. Concurrent
+// loads of a key are merged into one: callers share the result.
+// Missing items are always waited for, `mode` decides what happens to
+// stale ones. Loaded values are stored with the expiry given by the
+// loader, errors are returned and not cached.
+func (b *LRUCache) GetWithRefresh(key string, mode RefreshMode) (value interface{}, err error) {
+	if b.loader == nil {
+		return nil, ErrNoLoader
+	}
+	return b.getWithRefresh(key, mode, b.loadFunc(key))
+}
+
+// Get a key if it's not stale, otherwise run `loader`, store its
+// result expiring after `ttl`, or never if it's zero, and return it.
+// Like GetWithRefresh with BlockOnStale, concurrent loads of a key,
+// also the ones by GetWithRefresh, are merged into one and errors are
+// not cached.
+func (b *LRUCache) Fetch(key string, ttl time.Duration, loader func() (interface{}, error)) (interface{}, error) {
+	return b.getWithRefresh(key, BlockOnStale, func() (interface{}, error) {
+		var value interface{}
+		var err error
+		if perr := b.protect("Fetch", func() { value, err = loader() }); perr != nil {
+			err = perr
+		}
+		if err != nil {
+			return nil, err
+		}
+		var expire time.Time
+		if ttl > 0 {
+			expire = time.Now().Add(ttl)
+		}
+		b.insert(key, value, expire, time.Time{})
+		return value, nil
+	})
+}
+
+func (b *LRUCache) getWithRefresh(key string, mode RefreshMode, load func() (interface{}, error)) (value interface{}, err error) {
+	now := time.Now()
+	stale := false
+	b.lock.Lock()
+	e := b.lookup(key)
+	if e != nil {
+		stale = b.isExpired(e, now)
+		if !stale {
+			b.touchEntry(e)
+		}
+		value = e.value
+	}
+	b.lock.Unlock()
+
+	switch {
+	case e != nil && !stale:
+		b.materialize(key, &value)
+		return value, nil
+	case e != nil && mode == StaleWhileRevalidate:
+		b.flight.start(key, load)
+		b.materialize(key, &value)
+		return value, nil
+	}
+	return b.flight.do(key, load)
+}
+
+// Run the loader and store the result in the cache.
+func (b *LRUCache) loadFunc(key string) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		var value interface{}
+		var expire time.Time
+		var err error
+		if perr := b.protect("Loader", func() { value, expire, err = b.loader(key) }); perr != nil {
+			err = perr
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Still return the value if it didn't fit.
+		b.insert(key, value, expire, time.Time{})
+		return value, nil
+	}
+}
@@ -8,40 +8,148 @@
 //  - Multithreading supported using a mutex lock.
 //
 // Every element in the cache is linked to three data structures:
-// `table` map, `priorityQueue` ordered by expiry and `lruList`
+// `table` map, one of the `queues` ordered by expiry and `lruList`
 // ordered by decreasing popularity.
 
 package lrucache
 
 import (
 	"container/heap"
+	"context"
+	"errors"
+	"github.com/majek/goplayground/cache"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Returned by Set when there is no space for a new item: the cache
+// has zero capacity or all the candidates for eviction were vetoed.
+var ErrCacheFull = errors.New("lrucache: cache full")
+
+// TTL of items without expiry, see TTL.
+const NoExpiry = cache.NoExpiry
+
+// State only some options need is kept in side arrays of the cache,
+// indexed by the entry number, allocated when the option is used.
 type entry struct {
 	element Element     // list element. value is a pointer to this entry
 	key     string      // key is a key!
 	value   interface{} //
 	expire  time.Time   // time when the item is expired. it's okay to be stale.
 	index   int         // index for priority queue needs. -1 if entry is free
+	hits    int         // number of accesses, used by WithPromoteAfter
+	seq     uint64      // insertion order, breaks expiry ties
+	gen     uint64      // bumped on removal, invalidates EntryHandles
+	pins    int32       // Acquire calls not released yet
+	no      uint32      // position in the block of entries
 }
 
 type LRUCache struct {
-	lock          sync.Mutex
-	table         map[string]*entry // all entries in table must be in lruList
-	priorityQueue PriorityQueue     // some elements from table may be in priorityQueue
-	lruList       List              // every entry is either used and resides in lruList
-	freeList      List              // or free and is linked to freeList
+	lock      sync.RWMutex      // held for reading only by GetFresh
+	table     map[string]*entry // all entries in table must be in lruList
+	openTable *openTable        // replaces table if WithOpenAddressing is used
+	queues    []PriorityQueue   // by expiry class, some elements from table are in one
+	lruList   List              // every entry is either used and resides in lruList
+	freeList  List              // or free and is linked to freeList
+
+	evictionLog       *evictionLog  // nil unless WithEvictionLog is used
+	missLog           *missLog      // nil unless WithMissTracking is used
+	ghost             *ghostList    // nil unless WithGhostList is used
+	fullness          *fullness     // nil unless WithFullnessCallback is used
+	sketch            *freqSketch   // nil unless WithAdmissionFilter is used
+	hitWindow         *hitWindow    // nil unless WithHitRateWindow is used
+	expiryGranularity time.Duration // round expiry times to this, if set
+	janitor           *janitor      // nil unless WithJanitor is used
+	classJanitors     []*janitor    // by expiry class, see WithExpiryClasses
+	writeBehind       *writeBehind  // nil unless WithWriteBehind is used
+	budget            *Budget       // nil unless WithSharedBudget is used
+	promoteAfter      int           // accesses needed to reach the LRU front
+	slru              *slru         // nil unless WithSLRU is used
+	slruFraction      float64
+	canEvict          func(key string, value interface{}) bool
+	onEvict           func(key string, value interface{}, reason EvictReason)
+	onCallbackError   func(error)
+	demote            func(key string, value interface{}) (string, interface{}, time.Time, bool)
+	maxDemotions      int     // see WithDemotion
+	demotions         uint64  // see Stats
+	entrySizes        []int   // by entry number, see WithSharedBudget
+	entryClasses      []uint8 // by entry number, nil unless there are many expiry classes
+	entryDemotions    []uint8 // by entry number, times demoted, nil unless WithDemotion is used
+	rejections        uint64  // see Stats
+	writeThrough      func(ctx context.Context, key string, value interface{}, expire time.Time) error
+	peakLen           int     // highest Len since creation or ResetPeakLen
+	seq               uint64  // of the last inserted entry
+	reapLoadFactor    float64 // see WithProactiveExpiry
+	reapPerSet        int
+	diagRecords       int // see WithDiagnosticsBound
+	diagKeyLen        int
+	selfCheckEvery    int // see WithSelfCheck
+	selfCheckOps      int
+	selfCheckReport   func(error)
+	sizeOf            func(key string, value interface{}) int // see WithSizeAwareEviction
+	sizeWindow        int
+	budgetSize        func(key string, value interface{}) int
+	spreadWindow      time.Duration        // see WithExpirySpread
+	spreadBase        time.Time            // expiry of the first item of the batch
+	spreadN           int                  // items in the batch so far
+	removals          [evictReasons]uint64 // see Stats
+	hits, misses      uint64               // sampled lookups, see Stats
+	sampleRate        uint64               // see WithStatsSampling, 1 counts all
+	sampleState       uint64               // of the sampling random generator
+	evictionScans     uint64               // LRU entries looked at by freeSomeEntry
+	maxEvictionScan   int                  // see WithMaxEvictionScan
+	skewTolerance     time.Duration        // items expire this long after their expiry time
+	frozen            bool                 // see Freeze
+	failFrozen        bool                 // see WithFreezeErrors
+	draining          atomic.Bool          // see Drain
+	drained           chan struct{}        // closed when empty while draining
+	unfrozen          *sync.Cond           // signalled by Unfreeze
+
+	openAddressing bool                   // set by WithOpenAddressing
+	slotSize       int                    // set by WithValueSlots
+	slotData       []byte                 // the slots, by entry number
+	slotBoxes      []interface{}          // slot as last returned, reused to avoid boxing
+	defaultTTL     atomic.Int64           // time.Duration used by SetDefault
+	id             uint64                 // order of Init, see lockPair
+	forwards       map[handleRef]*forward // pinned entries moved by MoveTo
+
+	loader Loader // nil unless WithLoader is used
+	flight flight // loads in progress
 }
 
 // Initialize the LRU cache instance. O(capacity)
-func (b *LRUCache) Init(capacity uint) {
-	b.table = make(map[string]*entry, capacity)
-	b.priorityQueue = make([]*entry, 0, capacity)
+func (b *LRUCache) Init(capacity uint, options ...Option) {
+	for _, option := range options {
+		option(b)
+	}
+	b.id = initialized.Add(1)
+	b.boundDiagnostics()
+
+	if b.openAddressing {
+		b.openTable = newOpenTable(capacity)
+	} else {
+		b.table = make(map[string]*entry, capacity)
+	}
+	if len(b.queues) == 0 {
+		b.queues = make([]PriorityQueue, 1)
+	}
+	if b.sampleRate == 0 {
+		b.sampleRate = 1
+	}
+	b.sampleState = uint64(time.Now().UnixNano()) | 1
+	for i := range b.queues {
+		b.queues[i] = make([]*entry, 0, capacity)
+	}
 	b.lruList.Init()
+	if b.sketch != nil {
+		b.sketch.init(capacity)
+	}
+	if b.slruFraction > 0 {
+		b.slru = &slru{maxProtected: int(b.slruFraction * float64(capacity)), hot: make([]bool, capacity)}
+	}
 	b.freeList.Init()
-	heap.Init(&b.priorityQueue)
+	b.unfrozen = sync.NewCond(&b.lock)
 
 	// Reserve all the entries in one giant continous block of memory
 	arrayOfEntries := make([]entry, capacity)
@@ -49,20 +157,52 @@ func (b *LRUCache) Init(capacity uint) {
 		e := &arrayOfEntries[i]
 		e.element.Value = e
 		e.index = -1
+		e.no = uint32(i)
 		b.freeList.PushElementBack(&e.element)
 	}
+	if b.slotSize > 0 {
+		b.slotData = make([]byte, int(capacity)*b.slotSize)
+		b.slotBoxes = make([]interface{}, capacity)
+	}
+	if b.budget != nil {
+		b.entrySizes = make([]int, capacity)
+	}
+	if len(b.queues) > 1 {
+		b.entryClasses = make([]uint8, capacity)
+	}
+	if b.demote != nil {
+		b.entryDemotions = make([]uint8, capacity)
+	}
+
+	if b.janitor != nil {
+		go b.janitor.run(b)
+	}
+	for _, j := range b.classJanitors {
+		if j != nil {
+			go j.run(b)
+		}
+	}
+	if b.writeBehind != nil {
+		go b.writeBehind.run(b)
+	}
 }
 
 // Create new LRU cache instance. Allocate all the needed memory. O(capacity)
-func NewLRUCache(capacity uint) *LRUCache {
+func NewLRUCache(capacity uint, options ...Option) *LRUCache {
 	b := &LRUCache{}
-	b.Init(capacity)
+	b.Init(capacity, options...)
 	return b
 }
 
 // Give me the entry with lowest expiry field if it's before now.
 func (b *LRUCache) expiredEntry(now time.Time) *entry {
-	if len(b.priorityQueue) == 0 {
+	var head *entry
+	for i := range b.queues {
+		if q := b.queues[i]; len(q) > 0 && (head == nil || earlier(q[0], head)) {
+			head = q[0]
+		}
+	}
+	if head == nil {
 		return nil
 	}
 
@@ -70,33 +210,85 @@ func (b *LRUCache) expiredEntry(now time.Time) *entry {
 		// Fill it only when actually used.
 		now = time.Now()
 	}
-	e := b.priorityQueue[0]
-	if e.expire.Before(now) {
-		return e
+	if b.isExpired(head, now) {
+		return head
 	}
 	return nil
 }
 
+// Expiry queue of the entry.
+func (b *LRUCache) queue(e *entry) *PriorityQueue {
+	return &b.queues[b.classOf(e)]
+}
+
+// Whether the item is stale at `now`: it has an expiry and `now` is
+// more than the clock skew tolerance past it. An item is still fresh
+// at exactly its expiry time plus the tolerance. Every expiry check
+// goes through here.
+func (b *LRUCache) isExpired(e *entry, now time.Time) bool {
+	return !e.expire.IsZero() && e.expire.Before(now.Add(-b.skewTolerance))
+}
+
 // Give me the least loved entry.
 func (b *LRUCache) leastUsedEntry() *entry {
 	return b.lruList.Back().Value.(*entry)
 }
 
-func (b *LRUCache) freeSomeEntry(now time.Time) (e *entry, used bool) {
+// Find a slot for a new entry. If the returned entry is in use it
+// must be evicted for the given reason first. Returns nil if there is
+// no slot.
+func (b *LRUCache) freeSomeEntry(now time.Time) (e *entry, used bool, reason EvictReason) {
 	if b.freeList.Len() > 0 {
-		return b.freeList.Front().Value.(*entry), false
+		return b.freeList.Front().Value.(*entry), false, 0
 	}
 
 	e = b.expiredEntry(now)
-	if e != nil {
-		return e, true
+	if e != nil && b.evictable(e) {
+		return e, true, EvictExpired
 	}
 
-	if b.lruList.Len() == 0 {
-		return nil, false
+	// Walk from the least used entry towards the front, looking for
+	// one that may be evicted. With WithSizeAwareEviction pick the
+	// best of the first few.
+	var victim *entry
+	best, seen, scanned := 0, 0, 0
+	for el := b.lruList.Back(); el != nil; el = el.Prev() {
+		if b.maxEvictionScan > 0 && scanned == b.maxEvictionScan {
+			break
+		}
+		scanned += 1
+		b.evictionScans += 1
+		e := el.Value.(*entry)
+		if !b.evictable(e) {
+			continue
+		}
+		if b.sizeOf == nil {
+			return e, true, EvictCapacity
+		}
+		if score := b.evictionScore(e, seen); victim == nil || score > best {
+			victim, best = e, score
+		}
+		seen += 1
+		if seen == b.sizeWindow {
+			break
+		}
 	}
+	if victim != nil {
+		return victim, true, EvictCapacity
+	}
+	return nil, false, 0
+}
 
-	return b.leastUsedEntry(), true
+func (b *LRUCache) evictable(e *entry) bool {
+	if e.pins > 0 {
+		return false
+	}
+	if b.canEvict == nil {
+		return true
+	}
+	ok := false
+	b.protect("CanEvict", func() { ok = b.canEvict(e.key, e.value) })
+	return ok
 }
 
 // Move entry from used/lru list to a free list. Clear the entry as well.
@@ -106,13 +298,53 @@ func (b *LRUCache) removeEntry(e *entry) {
 	}
 
 	if e.index != -1 {
-		heap.Remove(&b.priorityQueue, e.index)
+		heap.Remove(b.queue(e), e.index)
+	}
+	if b.slru != nil {
+		b.slru.unlink(b, e)
+	} else {
+		b.lruList.Remove(&e.element)
 	}
-	b.lruList.Remove(&e.element)
 	b.freeList.PushElementFront(&e.element)
-	delete(b.table, e.key)
+	if b.openTable != nil {
+		b.openTable.del(e)
+	} else {
+		delete(b.table, e.key)
+	}
+	if b.budget != nil {
+		b.budget.used.Add(-int64(b.entrySizes[e.no]))
+	}
 	e.key = ""
 	e.value = nil
+	e.pins = 0
+	e.gen += 1
+	if b.drained != nil {
+		b.checkDrained()
+	}
+	if b.fullness != nil {
+		b.fullness.update(b)
+	}
+	b.selfCheckTick()
+}
+
+// Remove an entry on behalf of the user, recording why it is gone.
+func (b *LRUCache) evictEntry(e *entry, reason EvictReason, now time.Time) {
+	key, value := e.key, e.value
+	b.removeEntry(e)
+	b.removals[reason] += 1
+	if b.ghost != nil && reason == EvictCapacity {
+		b.ghost.add(key)
+	}
+	if b.evictionLog != nil {
+		if now.IsZero() {
+			now = time.Now()
+		}
+		b.evictionLog.add(EvictionRecord{Key: key, Reason: reason, Time: now})
+	}
+	if b.onEvict != nil {
+		value = peek(value)
+		b.protect("OnEvict", func() { b.onEvict(key, value, reason) })
+	}
 }
 
 func (b *LRUCache) insertEntry(e *entry) {
@@ -120,15 +352,54 @@ func (b *LRUCache) insertEntry(e *entry) {
 		panic("list freeList")
 	}
 
+	b.seq += 1
+	e.seq = b.seq
+	if b.budget != nil {
+		b.budget.used.Add(int64(b.entrySizes[e.no]))
+	}
 	if !e.expire.IsZero() {
-		heap.Push(&b.priorityQueue, e)
+		heap.Push(b.queue(e), e)
 	}
 	b.freeList.Remove(&e.element)
-	b.lruList.PushElementFront(&e.element)
-	b.table[e.key] = e
+	if b.slru != nil {
+		b.slru.insert(b, e)
+	} else {
+		b.lruList.PushElementFront(&e.element)
+	}
+	if b.openTable != nil {
+		b.openTable.set(e)
+	} else {
+		b.table[e.key] = e
+	}
+	if n := b.lruList.Len(); n > b.peakLen {
+		b.peakLen = n
+	}
+	if b.fullness != nil {
+		b.fullness.update(b)
+	}
+	b.selfCheckTick()
+}
+
+// Entry for the key, or nil if it's missing.
+func (b *LRUCache) lookup(key string) *entry {
+	if b.openTable != nil {
+		return b.openTable.get(key)
+	}
+	return b.table[key]
 }
 
 func (b *LRUCache) touchEntry(e *entry) {
+	e.hits += 1
+	if b.sketch != nil {
+		b.sketch.add(e.key)
+	}
+	if b.slru != nil {
+		b.slru.touch(b, e)
+		return
+	}
+	if e.hits < b.promoteAfter {
+		return
+	}
 	b.lruList.Remove(&e.element)
 	b.lruList.PushElementFront(&e.element)
 }
@@ -136,58 +407,152 @@ func (b *LRUCache) touchEntry(e *entry) {
 // Add an item to the cache overwriting existing one if it
 // exists. Allows specifing current time required to expire an
 // item when no more slots are used. Value must not be
-// nil. O(log(n)) if expiry is set, O(1) when clear.
-func (b *LRUCache) SetNow(key string, value interface{}, expire time.Time, now time.Time) {
+// nil. Fails with ErrCacheFull if there's no space for the
+// item. O(log(n)) if expiry is set, O(1) when clear.
+func (b *LRUCache) SetNow(key string, value interface{}, expire time.Time, now time.Time) error {
+	if b.writeThrough != nil {
+		if err := b.store(context.Background(), key, value, expire); err != nil {
+			return err
+		}
+	}
+	return b.insert(key, value, expire, now)
+}
+
+// SetNow skipping the write-through.
+func (b *LRUCache) insert(key string, value interface{}, expire time.Time, now time.Time) error {
 	b.lock.Lock()
 	defer b.lock.Unlock()
+	if err := b.waitUnfrozenOrFail(); err != nil {
+		return err
+	}
 
-	var used bool
+	return b.set(key, value, expire, now)
+}
 
-	e := b.table[key]
-	if e != nil {
-		used = true
-	} else {
-		e, used = b.freeSomeEntry(now)
-		if e == nil {
-			return
+// SetNow without locking.
+func (b *LRUCache) set(key string, value interface{}, expire time.Time, now time.Time) error {
+	return b.setClass(key, value, expire, now, -1)
+}
+
+// Set putting the item in expiry `class`, or in the class it already
+// has if negative.
+func (b *LRUCache) setClass(key string, value interface{}, expire time.Time, now time.Time, class int) error {
+	if b.draining.Load() {
+		return ErrDraining
+	}
+	var slotValue []byte
+	if b.slotSize > 0 {
+		var err error
+		if slotValue, err = b.slotValue(value); err != nil {
+			return err
 		}
 	}
-	if used {
+
+	hits := 1
+	e := b.lookup(key)
+	if e != nil {
+		// Overwriting keeps the popularity.
+		hits = e.hits
+		if class < 0 {
+			class = b.classOf(e)
+		}
 		b.removeEntry(e)
+	} else {
+		if b.reapPerSet > 0 && float64(b.lruList.Len()+1) > b.reapLoadFactor*float64(b.lruList.Len()+b.freeList.Len()) {
+			b.reap(now, b.reapPerSet)
+		}
+		var used bool
+		var reason EvictReason
+		admitted := b.sketch == nil
+		demoted := false
+		for {
+			e, used, reason = b.freeSomeEntry(now)
+			if e == nil {
+				return ErrCacheFull
+			}
+			if used && reason == EvictCapacity && !admitted {
+				if !b.admit(key, e) {
+					return ErrRejected
+				}
+				admitted = true
+			}
+			if !used || reason != EvictCapacity || b.demote == nil || demoted || !b.demoteEntry(e) {
+				break
+			}
+			demoted = true
+		}
+		if used {
+			b.evictEntry(e, reason, now)
+		}
 	}
 
+	expire = b.adjustExpire(expire)
+	if b.slotSize > 0 {
+		value = b.fillSlot(e, slotValue)
+	}
 	e.key = key
 	e.value = value
 	e.expire = expire
+	e.hits = hits
+	b.setClassOf(e, max(class, 0))
+	if b.entryDemotions != nil {
+		b.entryDemotions[e.no] = 0
+	}
+	if b.budget != nil {
+		b.entrySizes[e.no] = b.itemSize(key, value)
+	}
 	b.insertEntry(e)
+	if b.budget != nil {
+		b.enforceBudget(e, now)
+	}
+	if b.writeBehind != nil {
+		b.writeBehind.add(key, value)
+	}
+	return nil
+}
+
+// Apply WithExpiryGranularity and WithExpirySpread to a new expiry.
+func (b *LRUCache) adjustExpire(expire time.Time) time.Time {
+	if b.expiryGranularity > 0 && !expire.IsZero() {
+		expire = expire.Round(b.expiryGranularity)
+	}
+	if b.spreadWindow > 0 && !expire.IsZero() {
+		expire = b.spreadExpire(expire)
+	}
+	return expire
 }
 
 // Add an item to the cache overwriting existing one if it
-// exists. O(log(n)) if expiry is set, O(1) when clear.
-func (b *LRUCache) Set(key string, value interface{}, expire time.Time) {
-	b.SetNow(key, value, expire, time.Time{})
+// exists. Fails with ErrCacheFull if there's no space for the
+// item. O(log(n)) if expiry is set, O(1) when clear.
+func (b *LRUCache) Set(key string, value interface{}, expire time.Time) error {
+	return b.SetNow(key, value, expire, time.Time{})
 }
 
 // Get a key from the cache, possibly stale. Update its LRU score. O(1)
 func (b *LRUCache) Get(key string) (v interface{}, ok bool) {
+	defer b.materialize(key, &v)
 	b.lock.Lock()
 	defer b.lock.Unlock()
 
-	e := b.table[key]
+	e := b.lookup(key)
 	if e == nil {
+		b.missed(key)
 		return nil, false
 	}
 
 	b.touchEntry(e)
+	b.hit()
 	return e.value, true
 }
 
 // Get a key from the cache, possibly stale. Don't modify its LRU score. O(1)
 func (b *LRUCache) GetQuiet(key string) (v interface{}, ok bool) {
+	defer b.materialize(key, &v)
 	b.lock.Lock()
 	defer b.lock.Unlock()
 
-	e := b.table[key]
+	e := b.lookup(key)
 	if e == nil {
 		return nil, false
 	}
@@ -195,6 +560,40 @@ func (b *LRUCache) GetQuiet(key string) (v interface{}, ok bool) {
 	return e.value, true
 }
 
+// Time left until the item expires, zero or negative if it's stale,
+// NoExpiry if it has no expiry, without the value. Doesn't modify the
+// LRU score. O(1)
+func (b *LRUCache) TTL(key string) (ttl time.Duration, ok bool) {
+	now := time.Now()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	e := b.lookup(key)
+	if e == nil {
+		return 0, false
+	}
+	return ttlOf(e.expire, now), true
+}
+
+// Get a key from the cache, possibly stale, together with its
+// position in the LRU list: 0 is the most recently used item. Don't
+// modify its LRU score. Meant for diagnostics, walks the list. O(n)
+func (b *LRUCache) GetWithDepth(key string) (v interface{}, depth int, ok bool) {
+	defer b.materialize(key, &v)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	e := b.lookup(key)
+	if e == nil {
+		return nil, 0, false
+	}
+
+	for el := b.lruList.Front(); el != &e.element; el = el.Next() {
+		depth += 1
+	}
+	return e.value, depth, true
+}
+
 // Get a key from the cache, make sure it's not stale. Update its
 // LRU score. O(log(n)) if the item is expired.
 func (b *LRUCache) GetNotStale(key string) (value interface{}, ok bool) {
@@ -204,58 +603,221 @@ func (b *LRUCache) GetNotStale(key string) (value interface{}, ok bool) {
 // Get a key from the cache, make sure it's not stale. Update its
 // LRU score. O(log(n)) if the item is expired.
 func (b *LRUCache) GetNotStaleNow(key string, now time.Time) (value interface{}, ok bool) {
+	defer b.materialize(key, &value)
 	b.lock.Lock()
 	defer b.lock.Unlock()
 
-	e := b.table[key]
+	e := b.lookup(key)
 	if e == nil {
+		b.missed(key)
 		return nil, false
 	}
 
-	if e.expire.Before(now) {
-		b.removeEntry(e)
+	if b.isExpired(e, now) {
+		if !b.frozen && e.pins == 0 {
+			b.evictEntry(e, EvictStale, now)
+		}
+		b.missed(key)
 		return nil, false
 	}
 
 	b.touchEntry(e)
+	b.hit()
 	return e.value, true
 }
 
+// Get a key from the cache if it's not stale. Stale items are a miss
+// but are left in place. Only takes the lock for reading, so
+// concurrent GetFresh calls don't wait for each other, in exchange it
+// doesn't update the LRU score. Pair it with WithJanitor to get stale
+// items removed. O(1)
+func (b *LRUCache) GetFresh(key string) (value interface{}, ok bool) {
+	return b.GetFreshNow(key, time.Now())
+}
+
+// GetFresh with the time given.
+func (b *LRUCache) GetFreshNow(key string, now time.Time) (value interface{}, ok bool) {
+	defer b.materialize(key, &value)
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	e := b.lookup(key)
+	if e == nil {
+		return nil, false
+	}
+	if b.isExpired(e, now) {
+		return nil, false
+	}
+	return e.value, true
+}
+
+// Like GetNotStaleNow, but while holding the lock also evict up to
+// `maxReap` expired items from the head of the expiry queue, so stale
+// items don't pile up in a cache that is only read. Returns the number
+// of items evicted, including the requested one if it was stale. Does
+// nothing extra when frozen. O(maxReap*log(n))
+func (b *LRUCache) GetFreshReaping(key string, now time.Time, maxReap int) (value interface{}, ok bool, reaped int) {
+	defer b.materialize(key, &value)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if !b.frozen {
+		reaped = b.reap(now, maxReap)
+	}
+
+	e := b.lookup(key)
+	if e == nil {
+		return nil, false, reaped
+	}
+
+	if b.isExpired(e, now) {
+		if !b.frozen && e.pins == 0 {
+			b.evictEntry(e, EvictStale, now)
+			reaped += 1
+		}
+		return nil, false, reaped
+	}
+
+	b.touchEntry(e)
+	return e.value, true, reaped
+}
+
+// Like GetNotStale, but also hint if the item is going to expire
+// within `refreshAhead`, so that it can be refreshed before it goes
+// stale. Doesn't refresh anything itself. O(log(n)) if the item is
+// expired.
+func (b *LRUCache) GetWithRefreshHint(key string, refreshAhead time.Duration) (value interface{}, shouldRefresh bool, ok bool) {
+	return b.GetWithRefreshHintNow(key, refreshAhead, time.Now())
+}
+
+// Like GetNotStaleNow, but also hint if the item is going to expire
+// within `refreshAhead` from `now`. O(log(n)) if the item is expired.
+func (b *LRUCache) GetWithRefreshHintNow(key string, refreshAhead time.Duration, now time.Time) (value interface{}, shouldRefresh bool, ok bool) {
+	defer b.materialize(key, &value)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	e := b.lookup(key)
+	if e == nil {
+		return nil, false, false
+	}
+
+	if b.isExpired(e, now) {
+		if !b.frozen && e.pins == 0 {
+			b.evictEntry(e, EvictStale, now)
+		}
+		return nil, false, false
+	}
+
+	b.touchEntry(e)
+	shouldRefresh = !e.expire.IsZero() && !now.Add(refreshAhead).Before(e.expire)
+	return e.value, shouldRefresh, true
+}
+
 // Get and remove a key from the cache. O(log(n)) if the item is using expiry, O(1) otherwise.
 func (b *LRUCache) Del(key string) (v interface{}, ok bool) {
+	defer b.materialize(key, &v)
 	b.lock.Lock()
 	defer b.lock.Unlock()
+	b.waitUnfrozen()
 
-	e := b.table[key]
+	e := b.lookup(key)
 	if e == nil {
 		return nil, false
 	}
 
 	value := e.value
-	b.removeEntry(e)
+	b.evictEntry(e, EvictDeleted, time.Time{})
 	return value, true
 }
 
+// Remove a key only if `pred` approves its current value, checked
+// and removed atomically. `pred` is called with the lock held and must
+// not use the cache. O(log(n)) if the item is using expiry, O(1)
+// otherwise.
+func (b *LRUCache) DelIf(key string, pred func(value interface{}) bool) (deleted bool) {
+	e := b.lockResolved(key)
+	defer b.lock.Unlock()
+
+	if e == nil {
+		return false
+	}
+	del := false
+	b.protect("DelIf", func() { del = pred(e.value) })
+	if !del {
+		return false
+	}
+
+	b.evictEntry(e, EvictDeleted, time.Time{})
+	return true
+}
+
 // Evict all items from the cache. O(n*log(n))
 func (b *LRUCache) Clear() int {
 	b.lock.Lock()
 	defer b.lock.Unlock()
+	b.waitUnfrozen()
+
+	return b.clear()
+}
+
+// Clear without locking.
+func (b *LRUCache) clear() int {
+	var now time.Time
+	if b.evictionLog != nil {
+		now = time.Now()
+	}
 
 	// First, remove entries that have expiry set
-	l := len(b.priorityQueue)
-	for i := 0; i < l; i++ {
-		// This could be reduced to O(n).
-		b.removeEntry(b.priorityQueue[0])
+	l := 0
+	for i := range b.queues {
+		for len(b.queues[i]) > 0 {
+			// This could be reduced to O(n).
+			b.evictEntry(b.queues[i][0], EvictCleared, now)
+			l += 1
+		}
 	}
 
 	// Second, remove all remaining entries
 	r := b.lruList.Len()
 	for i := 0; i < r; i++ {
-		b.removeEntry(b.leastUsedEntry())
+		b.evictEntry(b.leastUsedEntry(), EvictCleared, now)
 	}
 	return l + r
 }
 
+// Key with its value, as returned by ClearAndReturn.
+type KeyValue struct {
+	Key   string
+	Value interface{}
+}
+
+// Evict all items from the cache and return them, most recently used
+// first, for example to move them elsewhere. Unlike Keys and Get
+// followed by Clear there is no window for a race. Allocates a slice
+// as big as the cache, use Clear when the items are not needed. O(n*log(n))
+func (b *LRUCache) ClearAndReturn() []KeyValue {
+	items := b.clearAndReturn()
+	for i := range items {
+		items[i].Value = resolve(items[i].Value)
+	}
+	return items
+}
+
+func (b *LRUCache) clearAndReturn() []KeyValue {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.waitUnfrozen()
+
+	items := make([]KeyValue, 0, b.lruList.Len())
+	for el := b.lruList.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*entry)
+		items = append(items, KeyValue{e.key, e.value})
+	}
+	b.clear()
+	return items
+}
+
 // Evict all the expired items. O(n*log(n))
 func (b *LRUCache) Expire() int {
 	return b.ExpireNow(time.Now())
@@ -265,19 +827,162 @@ func (b *LRUCache) Expire() int {
 func (b *LRUCache) ExpireNow(now time.Time) int {
 	b.lock.Lock()
 	defer b.lock.Unlock()
+	b.waitUnfrozen()
 
+	return b.expire(now)
+}
+
+// ExpireNow without locking.
+func (b *LRUCache) expire(now time.Time) int {
 	i := 0
 	for {
 		e := b.expiredEntry(now)
 		if e == nil {
 			break
 		}
-		b.removeEntry(e)
+		b.evictEntry(e, EvictExpired, now)
 		i += 1
 	}
 	return i
 }
 
+// Evict up to `max` expired items from the head of the expiry queue.
+func (b *LRUCache) reap(now time.Time, max int) int {
+	i := 0
+	for ; i < max; i++ {
+		e := b.expiredEntry(now)
+		if e == nil {
+			break
+		}
+		b.evictEntry(e, EvictExpired, now)
+	}
+	return i
+}
+
+// Keys of items that expire before `now`, without evicting them. The
+// heap is walked from the top and subtrees that are not expired yet
+// are skipped. Keys are in no particular order. O(k) for k expired
+// items.
+func (b *LRUCache) ExpiredKeys(now time.Time) []string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	var keys []string
+	for _, q := range b.queues {
+		stack := []int{0}
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if i >= len(q) {
+				continue
+			}
+			e := q[i]
+			if !b.isExpired(e, now) {
+				// The children can't expire earlier than the parent.
+				continue
+			}
+			keys = append(keys, e.key)
+			stack = append(stack, 2*i+1, 2*i+2)
+		}
+	}
+	return keys
+}
+
+// Keys of up to `n` most recently used items, most recent first. O(n)
+func (b *LRUCache) MostRecentlyUsed(n int) []string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if n > b.lruList.Len() {
+		n = b.lruList.Len()
+	}
+	keys := make([]string, 0, n)
+	for el := b.lruList.Front(); len(keys) < n; el = el.Next() {
+		keys = append(keys, el.Value.(*entry).key)
+	}
+	return keys
+}
+
+// Mark the existing `keys` as used, like Get does, without reading
+// them, for example to protect hot keys from a scan that is about to
+// run. Subject to WithPromoteAfter like Get. Takes the lock once.
+// Returns the number of keys that exist. O(len(keys))
+func (b *LRUCache) TouchMulti(keys []string) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	n := 0
+	for _, key := range keys {
+		if e := b.lookup(key); e != nil {
+			b.touchEntry(e)
+			n += 1
+		}
+	}
+	return n
+}
+
+// Get many keys at once, possibly stale, taking the lock once.
+// values[i] and found[i] are the result for keys[i], repeated keys
+// get the same result at every position. Update the LRU scores like
+// Get. O(len(keys))
+func (b *LRUCache) GetOrdered(keys []string) (values []interface{}, found []bool) {
+	values = make([]interface{}, len(keys))
+	found = make([]bool, len(keys))
+	defer func() {
+		for i := range values {
+			b.materialize(keys[i], &values[i])
+		}
+	}()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for i, key := range keys {
+		e := b.lookup(key)
+		if e == nil {
+			b.missed(key)
+			continue
+		}
+		b.touchEntry(e)
+		b.hit()
+		values[i], found[i] = e.value, true
+	}
+	return values, found
+}
+
+// If the key exists and isn't stale, move its expiry to `expire` and
+// mark it as used, like Get does. Otherwise add it with `value`, like
+// Set. Returns true if the key existed, false if it was added, and the
+// error of Set if it couldn't be added. O(log(n))
+func (b *LRUCache) TouchOrSet(key string, value interface{}, expire time.Time) (existed bool, err error) {
+	now := time.Now()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.waitUnfrozen()
+
+	e := b.lookup(key)
+	if e == nil || b.isExpired(e, now) {
+		return false, b.set(key, value, expire, now)
+	}
+	b.setExpire(e, b.adjustExpire(expire))
+	b.touchEntry(e)
+	return true, nil
+}
+
+// Change the expiry of an item in the cache, keeping the heap in
+// order. Pinned items are pushed on Release.
+func (b *LRUCache) setExpire(e *entry, expire time.Time) {
+	e.expire = expire
+	switch {
+	case e.pins > 0:
+	case e.index != -1 && expire.IsZero():
+		heap.Remove(b.queue(e), e.index)
+	case e.index != -1:
+		heap.Fix(b.queue(e), e.index)
+	case !expire.IsZero():
+		heap.Push(b.queue(e), e)
+	}
+}
+
 // Number of entries used in the LRU
 func (b *LRUCache) Len() int {
 	// yes. this stupid thing requires locking
@@ -287,6 +992,24 @@ func (b *LRUCache) Len() int {
 	return b.lruList.Len()
 }
 
+// Highest number of entries used since the cache was created or
+// ResetPeakLen was called. Tells whether the cache came close to its
+// capacity, for example during a traffic spike. O(1)
+func (b *LRUCache) PeakLen() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.peakLen
+}
+
+// Start tracking PeakLen again from the current Len.
+func (b *LRUCache) ResetPeakLen() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.peakLen = b.lruList.Len()
+}
+
 // Get the total capacity of the LRU
 func (b *LRUCache) Capacity() int {
 	// yes. this stupid thing requires locking
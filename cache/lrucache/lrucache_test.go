@@ -1,10 +1,21 @@
 package lrucache
 
 import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math"
 	"math/rand"
 	"runtime"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/majek/goplayground/cache/internal/workload"
 )
 
 func TestBasicExpiry(t *testing.T) {
@@ -345,6 +356,26 @@ func BenchmarkConcurrentGet(bb *testing.B) {
 	}
 }
 
+// Concurrent reads of fresh items with expiry set.
+func benchmarkConcurrentFreshGet(bb *testing.B, get func(b *LRUCache, key string)) {
+	b := createFilledBucket(time.Now().Add(time.Hour))
+
+	bb.ResetTimer()
+	bb.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			get(b, randomString(2))
+		}
+	})
+}
+
+func BenchmarkConcurrentGetNotStale(bb *testing.B) {
+	benchmarkConcurrentFreshGet(bb, func(b *LRUCache, key string) { b.GetNotStale(key) })
+}
+
+func BenchmarkConcurrentGetFresh(bb *testing.B) {
+	benchmarkConcurrentFreshGet(bb, func(b *LRUCache, key string) { b.GetFresh(key) })
+}
+
 func BenchmarkConcurrentSet(bb *testing.B) {
 	b := createFilledBucket(time.Now().Add(time.Duration(4)))
 
@@ -384,3 +415,2567 @@ func BenchmarkConcurrentSetNX(bb *testing.B) {
 		_ = <-ch
 	}
 }
+
+func TestEvictionLog(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(2, WithEvictionLog(3))
+
+	if len(b.RecentEvictions()) != 0 {
+		t.Error("expecting empty log")
+	}
+
+	now := time.Now()
+	b.Set("a", "va", time.Time{})
+	b.Set("b", "vb", now.Add(-time.Second))
+	b.Set("a", "va2", time.Time{}) // replace is not an eviction
+	b.Set("c", "vc", time.Time{})  // pushes out expired "b"
+	b.Set("d", "vd", time.Time{})  // pushes out least used "a"
+
+	r := b.RecentEvictions()
+	if len(r) != 2 {
+		t.Fatal("expecting two records")
+	}
+	if r[0].Key != "b" || r[0].Reason != EvictExpired {
+		t.Error("expecting b expired first", r[0])
+	}
+	if r[1].Key != "a" || r[1].Reason != EvictCapacity {
+		t.Error("expecting a evicted second", r[1])
+	}
+	if r[0].Time.IsZero() {
+		t.Error("expecting time to be set")
+	}
+
+	b.Del("c")
+	b.Clear()
+	r = b.RecentEvictions()
+	if len(r) != 3 {
+		t.Fatal("expecting log to be bounded")
+	}
+	if r[0].Key != "a" || r[1].Key != "c" || r[1].Reason != EvictDeleted ||
+		r[2].Key != "d" || r[2].Reason != EvictCleared {
+		t.Error("expecting different records", r)
+	}
+
+	if NewLRUCache(2).RecentEvictions() != nil {
+		t.Error("expecting no log by default")
+	}
+}
+
+func TestExpiryGranularity(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3, WithExpiryGranularity(time.Second))
+
+	base := time.Unix(1000, 0)
+	b.Set("a", "va", base.Add(1400*time.Millisecond))
+	b.Set("b", "vb", base.Add(1600*time.Millisecond))
+	b.Set("c", "vc", time.Time{})
+
+	if e := b.lookup("a").expire; !e.Equal(base.Add(time.Second)) {
+		t.Error("expecting expiry rounded down", e)
+	}
+	if e := b.lookup("b").expire; !e.Equal(base.Add(2 * time.Second)) {
+		t.Error("expecting expiry rounded up", e)
+	}
+	if !b.lookup("c").expire.IsZero() {
+		t.Error("expecting no expiry to stay zero")
+	}
+
+	if b.ExpireNow(base.Add(time.Second)) != 0 {
+		t.Error("expecting nothing expired at the boundary")
+	}
+	if b.ExpireNow(base.Add(time.Second+1)) != 1 {
+		t.Error("expecting a expired after the boundary")
+	}
+	if b.ExpireNow(base.Add(1900*time.Millisecond)) != 0 {
+		t.Error("expecting b not expired before the boundary")
+	}
+	if b.ExpireNow(base.Add(2*time.Second+1)) != 1 {
+		t.Error("expecting b expired after the boundary")
+	}
+	if b.Len() != 1 {
+		t.Error("expecting different length")
+	}
+}
+
+func TestReplaceAll(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3)
+
+	b.Set("a", "va", time.Time{})
+	b.Set("b", "vb", time.Time{})
+
+	future := time.Now().Add(time.Hour)
+	n := b.ReplaceAll(map[string]ValueExpire{
+		"b": {Value: "vb2", Expire: future},
+		"c": {Value: "vc"},
+	})
+	if n != 2 || b.Len() != 2 {
+		t.Error("expecting different length")
+	}
+	if _, ok := b.Get("a"); ok {
+		t.Error("expecting a to be gone")
+	}
+	if v, _ := b.Get("b"); v != "vb2" {
+		t.Error("expecting new value")
+	}
+	if e := b.lookup("b").expire; !e.Equal(future) {
+		t.Error("expecting expiry to be set")
+	}
+
+	n = b.ReplaceAll(map[string]ValueExpire{
+		"1": {Value: "v"}, "2": {Value: "v"},
+		"3": {Value: "v"}, "4": {Value: "v"},
+	})
+	if n != 3 || b.Capacity() != 3 {
+		t.Error("expecting capacity to be respected")
+	}
+
+	if b.ReplaceAll(nil) != 0 || b.Len() != 0 {
+		t.Error("expecting empty cache")
+	}
+}
+
+func TestExpiredKeys(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(10)
+
+	now := time.Now()
+	for i := 0; i < 8; i++ {
+		b.Set(string(rune('a'+i)), "v", now.Add(time.Duration(i-4)*time.Second))
+	}
+	b.Set("z", "vz", time.Time{})
+
+	keys := b.ExpiredKeys(now)
+	if len(keys) != 4 {
+		t.Fatal("expecting four expired keys", keys)
+	}
+	seen := map[string]bool{}
+	for _, k := range keys {
+		seen[k] = true
+	}
+	for _, k := range []string{"a", "b", "c", "d"} {
+		if !seen[k] {
+			t.Error("expecting key to be reported", k)
+		}
+	}
+
+	if b.Len() != 9 {
+		t.Error("expecting nothing evicted")
+	}
+	if b.ExpireNow(now) != len(keys) {
+		t.Error("expecting ExpireNow to reap the reported keys")
+	}
+	if len(b.ExpiredKeys(now)) != 0 {
+		t.Error("expecting no expired keys")
+	}
+}
+
+func TestMultimap(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(2)
+
+	if _, ok := b.GetList("a"); ok {
+		t.Error("expecting miss")
+	}
+	if b.Append("a", 1, time.Time{}) != 1 || b.Append("a", 2, time.Time{}) != 2 {
+		t.Error("expecting different list length")
+	}
+	if b.Len() != 1 {
+		t.Error("expecting list to be a single entry")
+	}
+
+	list, ok := b.GetList("a")
+	if !ok || len(list) != 2 || list[0] != 1 || list[1] != 2 {
+		t.Error("expecting list", list)
+	}
+	list[0] = 100
+	if l, _ := b.GetList("a"); l[0] != 1 {
+		t.Error("expecting a copy")
+	}
+
+	b.Append("a", 3, time.Time{})
+	if b.TrimList("a", 2) != 1 || b.TrimList("a", 2) != 0 {
+		t.Error("expecting one value trimmed")
+	}
+	if l, _ := b.GetList("a"); len(l) != 2 || l[0] != 2 || l[1] != 3 {
+		t.Error("expecting oldest value trimmed", l)
+	}
+	b.Append("c", 1, time.Time{})
+	b.Append("c", 2, time.Time{})
+	if b.TrimList("c", -1) != 2 {
+		t.Error("expecting negative max to drop every value")
+	}
+
+	b.Set("b", "vb", time.Time{})
+	if _, ok := b.GetList("b"); ok {
+		t.Error("expecting miss for non-list value")
+	}
+	if b.Append("b", "x", time.Time{}) != 1 {
+		t.Error("expecting non-list value to be replaced")
+	}
+
+	past := time.Now().Add(-time.Second)
+	b.Append("a", 4, past)
+	if _, ok := b.GetNotStale("a"); ok {
+		t.Error("expecting whole list to expire")
+	}
+
+	budget := NewBudget(100)
+	b = NewLRUCache(3, WithSharedBudget(budget, func(key string, value interface{}) int {
+		return len(value.([]interface{}))
+	}))
+	for i := 0; i < 10; i++ {
+		b.Append("a", i, time.Time{})
+	}
+	if b.TrimList("a", 1) != 9 || budget.Used() != 1 {
+		t.Error("expecting the budget to shrink with the list", budget.Used())
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3, WithJanitor(time.Millisecond))
+
+	b.Set("a", "va", time.Now().Add(-time.Second))
+	b.Set("b", "vb", time.Time{})
+	for i := 0; i < 1000 && b.Len() != 1; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if b.Len() != 1 {
+		t.Error("expecting janitor to evict expired item")
+	}
+
+	b.Close()
+	b.Close()
+
+	b.Set("c", "vc", time.Now().Add(-time.Second))
+	time.Sleep(5 * time.Millisecond)
+	if b.Len() != 2 {
+		t.Error("expecting janitor to be stopped")
+	}
+
+	// Close without a janitor is a no-op.
+	NewLRUCache(1).Close()
+}
+
+func TestGetWithRefreshHint(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3)
+
+	now := time.Now()
+	b.Set("a", "va", now.Add(5*time.Second))
+	b.Set("b", "vb", time.Time{})
+	b.Set("c", "vc", now.Add(-time.Second))
+
+	if v, r, ok := b.GetWithRefreshHintNow("a", time.Second, now); v != "va" || r || !ok {
+		t.Error("expecting fresh hit")
+	}
+	if v, r, ok := b.GetWithRefreshHintNow("a", 5*time.Second, now); v != "va" || !r || !ok {
+		t.Error("expecting hit with refresh hint")
+	}
+	if _, r, ok := b.GetWithRefreshHint("b", time.Hour); r || !ok {
+		t.Error("expecting no hint for item without expiry")
+	}
+	if _, _, ok := b.GetWithRefreshHint("c", time.Second); ok {
+		t.Error("expecting miss for expired item")
+	}
+	if _, _, ok := b.GetWithRefreshHint("miss", time.Second); ok {
+		t.Error("expecting miss")
+	}
+	if b.Len() != 2 {
+		t.Error("expecting expired item to be evicted")
+	}
+}
+
+func TestGetWithDepth(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3)
+
+	b.Set("a", "va", time.Time{})
+	b.Set("b", "vb", time.Time{})
+	b.Set("c", "vc", time.Time{})
+	b.Get("a")
+
+	for key, expected := range map[string]int{"a": 0, "c": 1, "b": 2} {
+		if _, d, ok := b.GetWithDepth(key); !ok || d != expected {
+			t.Error("expecting different depth", key, d)
+		}
+	}
+	if _, _, ok := b.GetWithDepth("miss"); ok {
+		t.Error("expecting miss")
+	}
+	if _, d, _ := b.GetWithDepth("b"); d != 2 {
+		t.Error("expecting LRU order not to change")
+	}
+}
+
+func TestMostRecentlyUsed(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3)
+
+	if len(b.MostRecentlyUsed(2)) != 0 {
+		t.Error("expecting no keys")
+	}
+	b.Set("a", "va", time.Time{})
+	b.Set("b", "vb", time.Time{})
+	b.Set("c", "vc", time.Time{})
+	b.Get("a")
+
+	if k := b.MostRecentlyUsed(2); len(k) != 2 || k[0] != "a" || k[1] != "c" {
+		t.Error("expecting different keys", k)
+	}
+	if k := b.MostRecentlyUsed(10); len(k) != 3 || k[2] != "b" {
+		t.Error("expecting all keys", k)
+	}
+}
+
+func TestGetFreshReaping(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(8)
+
+	now := time.Now()
+	for _, k := range []string{"a", "b", "c", "d"} {
+		b.Set(k, k, now.Add(-time.Second))
+	}
+	b.Set("e", "ve", now.Add(time.Hour))
+
+	if v, ok, reaped := b.GetFreshReaping("e", now, 3); !ok || v != "ve" || reaped != 3 {
+		t.Error("expecting bounded reaping", v, ok, reaped)
+	}
+	if b.Len() != 2 {
+		t.Error("expecting one stale item left", b.Len())
+	}
+	if _, ok, reaped := b.GetFreshReaping("x", now, 3); ok || reaped != 1 {
+		t.Error("expecting the rest reaped", reaped)
+	}
+	b.Set("f", "vf", now.Add(-time.Second))
+	if _, ok, reaped := b.GetFreshReaping("f", now, 0); ok || reaped != 1 {
+		t.Error("expecting stale item itself reaped", reaped)
+	}
+}
+
+func TestTouchMulti(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(4)
+
+	for _, k := range []string{"a", "b", "c", "d"} {
+		b.Set(k, k, time.Time{})
+	}
+	if n := b.TouchMulti([]string{"a", "x", "b"}); n != 2 {
+		t.Error("expecting two existing keys", n)
+	}
+	if k := b.MostRecentlyUsed(2); k[0] != "b" || k[1] != "a" {
+		t.Error("expecting touched keys in front", k)
+	}
+	b.Set("e", "e", time.Time{})
+	b.Set("f", "f", time.Time{})
+	if _, ok := b.GetQuiet("a"); !ok {
+		t.Error("expecting touched key to survive")
+	}
+	if _, ok := b.GetQuiet("c"); ok {
+		t.Error("expecting untouched key to be evicted")
+	}
+}
+
+// Hit rate of the hot keys under a workload mixing hot keys with
+// scans. Every round reads five hot keys, then reads again the four
+// keys the previous round scanned and scans four new ones.
+func scanWorkloadHitRate(b *LRUCache) float64 {
+	return workload.HitRate(b, 50*13, func(i int) (string, bool) {
+		round, j := i/13, i%13
+		switch {
+		case j < 5:
+			return fmt.Sprint("h", j), true
+		case j < 9:
+			return fmt.Sprint("s", round-1, "-", j-5), false
+		}
+		return fmt.Sprint("s", round, "-", j-9), false
+	})
+}
+
+func TestPromoteAfter(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3, WithPromoteAfter(2))
+
+	b.Set("a", "va", time.Time{})
+	b.Set("b", "vb", time.Time{})
+	b.Get("a")
+	b.Set("c", "vc", time.Time{})
+	// a was promoted, b was not and is the oldest.
+	b.Set("d", "vd", time.Time{})
+	if _, ok := b.GetQuiet("a"); !ok {
+		t.Error("expecting promoted item to survive")
+	}
+	if _, ok := b.GetQuiet("b"); ok {
+		t.Error("expecting oldest item to be evicted")
+	}
+	if _, ok := b.GetQuiet("c"); !ok {
+		t.Error("expecting newer item to survive")
+	}
+	if _, ok := b.GetQuiet("d"); !ok {
+		t.Error("expecting new item to be inserted")
+	}
+
+	// Not enough hits yet, a keeps its place.
+	b = NewLRUCache(3, WithPromoteAfter(3))
+	b.Set("a", "va", time.Time{})
+	b.Set("b", "vb", time.Time{})
+	b.Set("c", "vc", time.Time{})
+	b.Get("a")
+	b.Set("d", "vd", time.Time{})
+	if o := fmt.Sprint(b.EvictionOrder()); o != "[b c d]" {
+		t.Error("expecting a evicted without being promoted", o)
+	}
+
+	// Old items leave as usual once the cache is full.
+	b = NewLRUCache(10, WithPromoteAfter(2))
+	for i := 0; i < 20; i++ {
+		b.Set(fmt.Sprint("k", i), i, time.Time{})
+	}
+	if _, ok := b.GetQuiet("k0"); ok || b.Len() != 10 {
+		t.Error("expecting the oldest items evicted")
+	}
+
+	plain := scanWorkloadHitRate(NewLRUCache(10))
+	resistant := scanWorkloadHitRate(NewLRUCache(10, WithPromoteAfter(3)))
+	if resistant <= plain {
+		t.Error("expecting better hot hit rate under scans", plain, resistant)
+	}
+}
+
+func TestReservedBytes(t *testing.T) {
+	t.Parallel()
+
+	if EntrySize() == 0 {
+		t.Error("expecting non zero entry size")
+	}
+	if ReservedBytes(0) != 0 {
+		t.Error("expecting nothing reserved")
+	}
+	if ReservedBytes(1000) != 1000*ReservedBytes(1) {
+		t.Error("expecting linear growth")
+	}
+	if ReservedBytes(1) <= uint64(EntrySize()) {
+		t.Error("expecting priority queue slot to be included")
+	}
+	plain := ReservedBytes(1000)
+	for _, option := range []Option{
+		WithValueSlots(64),
+		WithSharedBudget(NewBudget(1<<20), nil),
+		WithExpiryClasses(0, 0),
+		WithSLRU(0.8),
+		WithAdmissionFilter(),
+		WithOpenAddressing(),
+		WithGhostList(100),
+	} {
+		if ReservedBytes(1000, option) <= plain {
+			t.Error("expecting the option to reserve more")
+		}
+	}
+	if ReservedBytes(1000, WithValueSlots(64)) < plain+1000*64 {
+		t.Error("expecting value slots to be included")
+	}
+}
+
+func TestSetLazy(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(2)
+
+	calls := int32(0)
+	init := func() interface{} {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(time.Millisecond)
+		return "va"
+	}
+	b.SetLazy("a", init, time.Time{})
+	if atomic.LoadInt32(&calls) != 0 {
+		t.Error("expecting init not to run on set")
+	}
+
+	done := make(chan interface{})
+	for i := 0; i < 4; i++ {
+		go func() {
+			v, _ := b.Get("a")
+			done <- v
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		if v := <-done; v != "va" {
+			t.Error("expecting materialized value", v)
+		}
+	}
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Error("expecting init to run once")
+	}
+	if _, ok := b.lookup("a").value.(*lazyValue); ok {
+		t.Error("expecting value to be memoized")
+	}
+	if v, _ := b.Del("a"); v != "va" {
+		t.Error("expecting materialized value on del")
+	}
+
+	b.SetLazy("b", init, time.Time{})
+	b.Set("c", "vc", time.Time{})
+	b.Set("d", "vd", time.Time{})
+	if _, ok := b.GetQuiet("b"); ok {
+		t.Error("expecting b to be evicted")
+	}
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Error("expecting init to never run for evicted item")
+	}
+}
+
+func TestSetLazyInitUsesCache(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3)
+	b.Set("other", "vo", time.Time{})
+	init := func() interface{} {
+		v, _ := b.GetQuiet("other")
+		return v
+	}
+
+	done := make(chan bool)
+	go func() {
+		b.SetLazy("a", init, time.Time{})
+		h, ok := b.Acquire("a")
+		ok = ok && h.Value() == "vo"
+		h.Release()
+		b.SetLazy("b", init, time.Time{})
+		ok = ok && b.DelIf("b", func(v interface{}) bool { return v == "vo" })
+		done <- ok
+	}()
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Error("expecting lazy values computed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expecting init not to run with the lock held")
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3)
+
+	past := time.Now().Add(-time.Second)
+	b.Set("a", "va", time.Time{})
+	b.Set("b", "vb", past)
+
+	b.Freeze()
+	if !b.Frozen() {
+		t.Error("expecting frozen")
+	}
+
+	done := make(chan bool)
+	go func() {
+		b.Set("c", "vc", time.Time{})
+		b.Del("a")
+		done <- true
+	}()
+
+	if v, _ := b.Get("a"); v != "va" {
+		t.Error("expecting reads to work")
+	}
+	if _, ok := b.GetNotStale("b"); ok {
+		t.Error("expecting stale miss")
+	}
+	if b.Len() != 2 {
+		t.Error("expecting stale item not to be evicted while frozen")
+	}
+	select {
+	case <-done:
+		t.Error("expecting writes to block")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	b.Unfreeze()
+	<-done
+	if b.Frozen() {
+		t.Error("expecting not frozen")
+	}
+	if _, ok := b.Get("a"); ok {
+		t.Error("expecting blocked del to complete")
+	}
+	if v, _ := b.Get("c"); v != "vc" {
+		t.Error("expecting blocked set to complete")
+	}
+}
+
+func TestFreezeJanitor(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3, WithJanitor(time.Millisecond))
+
+	b.Set("a", "va", time.Now().Add(20*time.Millisecond))
+	b.Freeze()
+	time.Sleep(40 * time.Millisecond)
+	if b.Len() != 1 {
+		t.Error("expecting janitor to skip frozen cache")
+	}
+	// Must not hang.
+	b.Close()
+}
+
+func TestFreezeErrors(t *testing.T) {
+	t.Parallel()
+	stored := 0
+	b := NewLRUCache(3, WithFreezeErrors(), WithWriteThrough(func(ctx context.Context, key string, value interface{}, expire time.Time) error {
+		stored += 1
+		return nil
+	}))
+
+	b.Set("a", "va", time.Time{})
+	b.Freeze()
+	if err := b.Set("b", "vb", time.Time{}); err != ErrFrozen {
+		t.Error("expecting ErrFrozen", err)
+	}
+	if err := b.SetClass("b", "vb", time.Time{}, 0); err != ErrFrozen {
+		t.Error("expecting ErrFrozen from SetClass", err)
+	}
+	if _, _, err := b.WarmFrom(strings.NewReader(`{"key":"c","value":1}`)); err != ErrFrozen {
+		t.Error("expecting ErrFrozen from WarmFrom", err)
+	}
+	if stored != 1 || b.Len() != 1 {
+		t.Error("expecting nothing written while frozen", stored, b.Len())
+	}
+	b.Unfreeze()
+	if err := b.Set("b", "vb", time.Time{}); err != nil {
+		t.Error("expecting Set to work again", err)
+	}
+}
+
+func TestGetWithRefresh(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewLRUCache(1).GetWithRefresh("a", BlockOnStale); err != ErrNoLoader {
+		t.Error("expecting no loader error")
+	}
+
+	calls := int32(0)
+	release := make(chan bool)
+	loader := func(key string) (interface{}, time.Time, error) {
+		n := atomic.AddInt32(&calls, 1)
+		<-release
+		if key == "fail" {
+			return nil, time.Time{}, errors.New("failed")
+		}
+		return fmt.Sprintf("%s%d", key, n), time.Now().Add(time.Hour), nil
+	}
+	b := NewLRUCache(3, WithLoader(loader))
+
+	// Concurrent misses share a single load.
+	done := make(chan interface{})
+	for i := 0; i < 4; i++ {
+		go func() {
+			v, _ := b.GetWithRefresh("a", BlockOnStale)
+			done <- v
+		}()
+	}
+	time.Sleep(5 * time.Millisecond)
+	close(release)
+	for i := 0; i < 4; i++ {
+		if v := <-done; v != "a1" {
+			t.Error("expecting loaded value", v)
+		}
+	}
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Error("expecting a single load")
+	}
+
+	// Fresh hit doesn't load.
+	if v, err := b.GetWithRefresh("a", BlockOnStale); v != "a1" || err != nil {
+		t.Error("expecting cached value")
+	}
+
+	// Stale item, blocking.
+	b.Set("a", "old", time.Now().Add(-time.Second))
+	if v, _ := b.GetWithRefresh("a", BlockOnStale); v != "a2" {
+		t.Error("expecting fresh value", v)
+	}
+
+	// Stale item, served while revalidating.
+	b.Set("a", "old", time.Now().Add(-time.Second))
+	if v, _ := b.GetWithRefresh("a", StaleWhileRevalidate); v != "old" {
+		t.Error("expecting stale value", v)
+	}
+	for i := 0; i < 1000; i++ {
+		if v, _ := b.GetQuiet("a"); v == "a3" {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if v, _ := b.GetQuiet("a"); v != "a3" {
+		t.Error("expecting background refresh", v)
+	}
+
+	// Errors are not cached.
+	if _, err := b.GetWithRefresh("fail", StaleWhileRevalidate); err == nil {
+		t.Error("expecting error")
+	}
+	if _, ok := b.GetQuiet("fail"); ok {
+		t.Error("expecting error not to be cached")
+	}
+}
+
+func TestClearAndReturn(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3)
+
+	if len(b.ClearAndReturn()) != 0 {
+		t.Error("expecting nothing")
+	}
+
+	b.Set("a", "va", time.Now().Add(time.Hour))
+	b.Set("b", "vb", time.Time{})
+	b.SetLazy("c", func() interface{} { return "vc" }, time.Time{})
+
+	items := b.ClearAndReturn()
+	if b.Len() != 0 {
+		t.Error("expecting empty cache")
+	}
+	if len(items) != 3 {
+		t.Fatal("expecting all items")
+	}
+	if items[0] != (KeyValue{"c", "vc"}) || items[1] != (KeyValue{"b", "vb"}) ||
+		items[2] != (KeyValue{"a", "va"}) {
+		t.Error("expecting different items", items)
+	}
+}
+
+func TestClockSkewTolerance(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3, WithClockSkewTolerance(time.Second))
+
+	now := time.Now()
+	b.Set("within", "v", now.Add(-500*time.Millisecond))
+	b.Set("beyond", "v", now.Add(-1500*time.Millisecond))
+
+	if _, ok := b.GetNotStaleNow("within", now); !ok {
+		t.Error("expecting item within tolerance to be fresh")
+	}
+	if _, ok := b.GetNotStaleNow("beyond", now); ok {
+		t.Error("expecting item beyond tolerance to be stale")
+	}
+
+	b.Set("beyond", "v", now.Add(-1500*time.Millisecond))
+	if k := b.ExpiredKeys(now); len(k) != 1 || k[0] != "beyond" {
+		t.Error("expecting only item beyond tolerance", k)
+	}
+	if b.ExpireNow(now) != 1 {
+		t.Error("expecting only item beyond tolerance to expire")
+	}
+	if b.ExpireNow(now.Add(time.Second)) != 1 {
+		t.Error("expecting item to expire once past tolerance")
+	}
+}
+
+func TestIterator(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(4)
+
+	b.Set("a", "va", time.Time{})
+	b.Set("b", "vb", time.Time{})
+	b.Set("c", "vc", time.Time{})
+
+	it := b.Iterator()
+	b.Del("b")
+	b.Set("d", "vd", time.Time{})
+
+	var keys []string
+	for it.Next() {
+		k, v := it.Current()
+		if v != "v"+k {
+			t.Error("expecting matching value", k, v)
+		}
+		// Safe to use the cache while iterating.
+		b.Get(k)
+		keys = append(keys, k)
+	}
+	if len(keys) != 2 || keys[0] != "c" || keys[1] != "a" {
+		t.Error("expecting snapshot without deleted key", keys)
+	}
+	if it.Next() {
+		t.Error("expecting iterator to stay exhausted")
+	}
+	if k, v := it.Current(); k != "" || v != nil {
+		t.Error("expecting no current item")
+	}
+}
+
+func TestOpenTable(t *testing.T) {
+	t.Parallel()
+	const capacity = 64
+	table := newOpenTable(capacity)
+	reference := map[string]*entry{}
+
+	// Short keys collide a lot, exercising probing and back shifts.
+	for i := 0; i < 20000; i++ {
+		key := randomString(2)
+		switch {
+		case reference[key] != nil && rand.Intn(2) == 0:
+			table.del(reference[key])
+			delete(reference, key)
+		case reference[key] == nil && len(reference) < capacity:
+			e := &entry{key: key}
+			table.set(e)
+			reference[key] = e
+		}
+		if table.get(key) != reference[key] {
+			t.Fatal("expecting table to match reference", key)
+		}
+	}
+	for key, e := range reference {
+		if table.get(key) != e {
+			t.Fatal("expecting table to match reference", key)
+		}
+	}
+}
+
+func TestOpenAddressing(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3, WithOpenAddressing())
+	if b.table != nil {
+		t.Error("expecting builtin map not to be allocated")
+	}
+
+	b.Set("a", "va", time.Time{})
+	b.Set("b", "vb", time.Time{})
+	b.Set("c", "vc", time.Time{})
+	b.Set("d", "vd", time.Time{})
+	if _, ok := b.Get("a"); ok {
+		t.Error("expecting a evicted")
+	}
+	if v, _ := b.Get("b"); v != "vb" {
+		t.Error("expecting hit")
+	}
+	if v, _ := b.Del("c"); v != "vc" || b.Len() != 2 {
+		t.Error("expecting del")
+	}
+	if b.Clear() != 2 {
+		t.Error("expecting different length")
+	}
+
+	NewLRUCache(0, WithOpenAddressing()).Set("a", "va", time.Time{})
+}
+
+func benchmarkKeys() []string {
+	keys := make([]string, 4096)
+	for i := range keys {
+		keys[i] = randomString(8)
+	}
+	return keys
+}
+
+func benchmarkGet(bb *testing.B, options ...Option) {
+	keys := benchmarkKeys()
+	b := NewLRUCache(uint(len(keys)), options...)
+	for _, k := range keys {
+		b.Set(k, "v", time.Time{})
+	}
+	bb.ReportAllocs()
+	bb.ResetTimer()
+	for i := 0; i < bb.N; i++ {
+		b.Get(keys[i%len(keys)])
+	}
+}
+
+func benchmarkSet(bb *testing.B, options ...Option) {
+	keys := benchmarkKeys()
+	b := NewLRUCache(uint(len(keys)/2), options...)
+	bb.ReportAllocs()
+	bb.ResetTimer()
+	for i := 0; i < bb.N; i++ {
+		b.Set(keys[i%len(keys)], "v", time.Time{})
+	}
+}
+
+func BenchmarkGetMap(bb *testing.B)            { benchmarkGet(bb) }
+func BenchmarkGetOpenAddressing(bb *testing.B) { benchmarkGet(bb, WithOpenAddressing()) }
+func BenchmarkSetMap(bb *testing.B)            { benchmarkSet(bb) }
+func BenchmarkSetOpenAddressing(bb *testing.B) { benchmarkSet(bb, WithOpenAddressing()) }
+
+func BenchmarkSetValueSlots(bb *testing.B) {
+	keys := benchmarkKeys()
+	b := NewLRUCache(uint(len(keys)/2), WithValueSlots(64))
+	var value interface{} = make([]byte, 64)
+	bb.ReportAllocs()
+	bb.ResetTimer()
+	for i := 0; i < bb.N; i++ {
+		b.Set(keys[i%len(keys)], value, time.Time{})
+	}
+}
+
+func TestDelIf(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3)
+
+	b.Set("a", "v1", time.Time{})
+	isV1 := func(v interface{}) bool { return v == "v1" }
+
+	if b.DelIf("miss", isV1) {
+		t.Error("expecting miss")
+	}
+	b.Set("a", "v2", time.Time{})
+	if b.DelIf("a", isV1) {
+		t.Error("expecting newer value to be kept")
+	}
+	b.Set("a", "v1", time.Time{})
+	if !b.DelIf("a", isV1) || b.Len() != 0 {
+		t.Error("expecting matching value to be deleted")
+	}
+}
+
+func TestPriorityQueueStats(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(10)
+
+	if s := b.PriorityQueueStats(); s.Len != 0 || !s.MinExpire.IsZero() {
+		t.Error("expecting empty stats", s)
+	}
+	if _, ok := b.NextExpiry(); ok {
+		t.Error("expecting no expiry")
+	}
+
+	base := time.Unix(1000, 0)
+	for _, d := range []int{5, 3, 9, 1, 7, 2} {
+		b.Set(string(rune('a'+d)), "v", base.Add(time.Duration(d)*time.Second))
+	}
+	b.Set("z", "v", time.Time{})
+
+	s := b.PriorityQueueStats()
+	if s.Len != 6 {
+		t.Error("expecting items without expiry to be skipped")
+	}
+	if !s.MinExpire.Equal(base.Add(time.Second)) || !s.HeadExpire.Equal(s.MinExpire) {
+		t.Error("expecting different min", s)
+	}
+	if !s.MaxExpire.Equal(base.Add(9 * time.Second)) {
+		t.Error("expecting different max", s)
+	}
+	if next, ok := b.NextExpiry(); !ok || !next.Equal(s.MinExpire) {
+		t.Error("expecting next expiry to be the min")
+	}
+}
+
+func TestWithLock(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3)
+
+	b.Set("a", "va", time.Time{})
+	var saved *Tx
+	b.WithLock(func(tx *Tx) {
+		v, ok := tx.Get("a")
+		if !ok {
+			t.Error("expecting hit")
+		}
+		tx.Del("a")
+		tx.Set("b", v, time.Time{})
+		if _, ok := tx.Get("a"); ok {
+			t.Error("expecting miss")
+		}
+		saved = tx
+	})
+	if v, _ := b.Get("b"); v != "va" || b.Len() != 1 {
+		t.Error("expecting value to be moved")
+	}
+
+	if rec(func() { saved.Get("b") }) != 1 {
+		t.Error("expecting panic when used outside of WithLock")
+	}
+}
+
+func TestDefaultTTL(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3)
+
+	b.SetDefault("a", "va")
+	if !b.lookup("a").expire.IsZero() {
+		t.Error("expecting no expiry by default")
+	}
+
+	b.SetDefaultTTL(time.Hour)
+	if b.DefaultTTL() != time.Hour {
+		t.Error("expecting different default")
+	}
+	before := time.Now()
+	b.SetDefault("b", "vb")
+	if e := b.lookup("b").expire; e.Before(before.Add(time.Hour)) || e.After(time.Now().Add(time.Hour)) {
+		t.Error("expecting default TTL to be used", e)
+	}
+	if !b.lookup("a").expire.IsZero() {
+		t.Error("expecting existing items to be unaffected")
+	}
+
+	c := NewLRUCache(3, WithDefaultTTL(-time.Second))
+	c.SetDefault("a", "va")
+	if !c.lookup("a").expire.IsZero() {
+		t.Error("expecting non positive TTL to mean no expiry")
+	}
+
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			b.SetDefaultTTL(time.Duration(i) * time.Second)
+		}
+		done <- true
+	}()
+	for i := 0; i < 100; i++ {
+		b.SetDefault("c", "vc")
+	}
+	<-done
+}
+
+func TestCanEvict(t *testing.T) {
+	t.Parallel()
+	inUse := map[string]bool{"a": true, "b": true}
+	b := NewLRUCache(3, WithCanEvict(func(key string, value interface{}) bool {
+		return !inUse[key]
+	}))
+
+	past := time.Now().Add(-time.Second)
+	b.Set("a", "va", past)
+	b.Set("b", "vb", time.Time{})
+	b.Set("c", "vc", time.Time{})
+
+	// "a" is both expired and least used, but vetoed.
+	if err := b.Set("d", "vd", time.Time{}); err != nil {
+		t.Error("expecting set to succeed", err)
+	}
+	if _, ok := b.GetQuiet("a"); !ok {
+		t.Error("expecting vetoed item to stay")
+	}
+	if _, ok := b.GetQuiet("c"); ok {
+		t.Error("expecting first evictable item to be evicted")
+	}
+
+	inUse["d"] = true
+	if err := b.Set("e", "ve", time.Time{}); err != ErrCacheFull {
+		t.Error("expecting cache full", err)
+	}
+	if b.Len() != 3 {
+		t.Error("expecting nothing evicted")
+	}
+	if err := b.Set("a", "va2", time.Time{}); err != nil {
+		t.Error("expecting overwrite to work", err)
+	}
+
+	if err := NewLRUCache(0).Set("a", "va", time.Time{}); err != ErrCacheFull {
+		t.Error("expecting cache full for zero capacity")
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(4)
+
+	now := time.Now()
+	b.Set("a", "va", time.Time{})
+	b.Set("b", 42, now.Add(time.Hour))
+	b.Set("c", "vc", now.Add(10*time.Second))
+	b.Set("d", "vd", now.Add(-time.Second))
+	b.Get("a")
+
+	var buf bytes.Buffer
+	if err := b.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	c := NewLRUCache(4)
+	if n, err := c.Load(&buf); n != 3 || err != nil {
+		t.Fatal("expecting expired item to be skipped", n, err)
+	}
+	if v, _ := c.GetQuiet("b"); v != 42 {
+		t.Error("expecting value to survive", v)
+	}
+	if d := c.lookup("b").expire.Sub(b.lookup("b").expire); d < 0 || d > time.Second {
+		t.Error("expecting remaining TTL to survive", d)
+	}
+	if !c.lookup("a").expire.IsZero() {
+		t.Error("expecting no expiry to survive")
+	}
+	if k := c.MostRecentlyUsed(3); k[0] != "a" || k[1] != "c" || k[2] != "b" {
+		t.Error("expecting LRU order to survive", k)
+	}
+
+	buf.Reset()
+	written, skipped, err := b.SaveCompact(&buf, time.Minute)
+	if written != 2 || skipped != 2 || err != nil {
+		t.Error("expecting soon to expire items skipped", written, skipped, err)
+	}
+	c.Clear()
+	c.Load(&buf)
+	if _, ok := c.GetQuiet("c"); ok || c.Len() != 2 {
+		t.Error("expecting compact snapshot")
+	}
+
+	if _, err := c.Load(bytes.NewBufferString("garbage")); err == nil {
+		t.Error("expecting error")
+	}
+
+	// Snapshots from before the TTL stored the expiry.
+	buf.Reset()
+	enc := gob.NewEncoder(&buf)
+	for _, item := range []struct {
+		Key    string
+		Value  interface{}
+		Expire time.Time
+	}{{"x", "vx", time.Time{}}, {"y", "vy", now.Add(time.Hour)}, {"z", "vz", now.Add(-time.Hour)}} {
+		enc.Encode(&item)
+	}
+	c.Clear()
+	c.Load(&buf)
+	if ttl, _ := c.TTL("y"); c.Len() != 2 || !c.lookup("x").expire.IsZero() || ttl < 59*time.Minute {
+		t.Error("expecting legacy snapshot loaded", c.Len(), ttl)
+	}
+}
+
+func TestOnEvict(t *testing.T) {
+	t.Parallel()
+	var evicted []string
+	b := NewLRUCache(2, WithOnEvict(func(key string, value interface{}, reason EvictReason) {
+		evicted = append(evicted, fmt.Sprint(key, value, reason))
+	}))
+
+	b.Set("a", "va", time.Time{})
+	b.Set("a", "va2", time.Time{})
+	b.Set("b", "vb", time.Time{})
+	b.Set("c", "vc", time.Time{})
+	b.Del("b")
+	if len(evicted) != 2 || evicted[0] != "ava2capacity" || evicted[1] != "bvbdeleted" {
+		t.Error("expecting evictions without overwrites", evicted)
+	}
+}
+
+func TestCallbackPanic(t *testing.T) {
+	t.Parallel()
+	var errs []error
+	b := NewLRUCache(1,
+		WithOnEvict(func(string, interface{}, EvictReason) { panic("boom") }),
+		WithCallbackErrorHandler(func(err error) { errs = append(errs, err) }))
+
+	b.Set("a", "va", time.Time{})
+	if err := b.Set("b", "vb", time.Time{}); err != nil {
+		t.Error("expecting set to succeed", err)
+	}
+	if v, ok := b.Get("b"); !ok || v != "vb" || b.Len() != 1 {
+		t.Error("expecting consistent cache")
+	}
+	b.Del("b")
+	if len(errs) != 2 {
+		t.Fatal("expecting the panics to be handled", errs)
+	}
+	if p, ok := errs[0].(*CallbackPanic); !ok || p.Callback != "OnEvict" || p.Value != "boom" {
+		t.Error("expecting callback panic", errs[0])
+	}
+
+	c := NewLRUCache(1, WithCanEvict(func(string, interface{}) bool { panic("boom") }))
+	c.Set("a", "va", time.Time{})
+	if err := c.Set("b", "vb", time.Time{}); err != ErrCacheFull {
+		t.Error("expecting panic to veto the eviction", err)
+	}
+
+	d := NewLRUCache(1, WithLoader(func(string) (interface{}, time.Time, error) { panic("boom") }))
+	if _, err := d.GetWithRefresh("a", BlockOnStale); err == nil {
+		t.Error("expecting loader panic to fail the load")
+	}
+	d.Set("a", "va", time.Time{})
+	if d.DelIf("a", func(interface{}) bool { panic("boom") }) || d.Len() != 1 {
+		t.Error("expecting panicking predicate to keep the item")
+	}
+}
+
+func TestPeakLen(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(4)
+
+	b.Set("a", "va", time.Time{})
+	b.Set("b", "vb", time.Time{})
+	b.Set("c", "vc", time.Time{})
+	b.Del("a")
+	b.Del("b")
+	if b.PeakLen() != 3 || b.Len() != 1 {
+		t.Error("expecting peak to stay", b.PeakLen())
+	}
+	b.ResetPeakLen()
+	if b.PeakLen() != 1 {
+		t.Error("expecting peak reset to the current length", b.PeakLen())
+	}
+}
+
+func TestTTLHistogram(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(8)
+
+	now := time.Now()
+	b.Set("a", "va", time.Time{})
+	b.Set("b", "vb", now.Add(-time.Second))
+	b.Set("c", "vc", now.Add(500*time.Millisecond))
+	b.Set("d", "vd", now.Add(time.Second))
+	b.Set("e", "ve", now.Add(30*time.Second))
+	b.Set("f", "vf", now.Add(time.Hour))
+
+	counts := b.TTLHistogram(now, []time.Duration{time.Second, 10 * time.Second, time.Minute})
+	if fmt.Sprint(counts) != "[2 1 1 1 1]" {
+		t.Error("expecting different counts", counts)
+	}
+}
+
+func TestRename(t *testing.T) {
+	t.Parallel()
+	for _, b := range []*LRUCache{NewLRUCache(3), NewLRUCache(3, WithOpenAddressing())} {
+		now := time.Now()
+		b.Set("a", "va", now.Add(time.Hour))
+		b.Set("b", "vb", time.Time{})
+		b.Set("c", "vc", time.Time{})
+
+		if b.Rename("x", "y") {
+			t.Error("expecting no rename of a missing key")
+		}
+		if !b.Rename("a", "b") || b.Len() != 2 {
+			t.Error("expecting rename over an existing key")
+		}
+		if _, ok := b.GetQuiet("a"); ok {
+			t.Error("expecting old key to be gone")
+		}
+		if v, ok := b.GetQuiet("b"); !ok || v != "va" || !b.lookup("b").expire.Equal(now.Add(time.Hour)) {
+			t.Error("expecting value and expiry to be kept")
+		}
+		if k := b.MostRecentlyUsed(2); k[0] != "c" || k[1] != "b" {
+			t.Error("expecting LRU position to be kept", k)
+		}
+		b.ExpireNow(now.Add(2 * time.Hour))
+		if b.Len() != 1 {
+			t.Error("expecting renamed item to expire")
+		}
+	}
+}
+
+func TestMaxInflight(t *testing.T) {
+	t.Parallel()
+	started := make(chan bool)
+	release := make(chan bool)
+	loader := func(key string) (interface{}, time.Time, error) {
+		started <- true
+		<-release
+		return key, time.Time{}, nil
+	}
+	b := NewLRUCache(10, WithLoader(loader), WithMaxInflight(2, FailWhenFull))
+
+	done := make(chan error)
+	for _, k := range []string{"a", "b"} {
+		go func(k string) {
+			_, err := b.GetWithRefresh(k, BlockOnStale)
+			done <- err
+		}(k)
+		<-started
+	}
+	if _, err := b.GetWithRefresh("c", BlockOnStale); err != ErrTooManyInflight {
+		t.Error("expecting overflow to fail", err)
+	}
+	close(release)
+	if <-done != nil || <-done != nil {
+		t.Error("expecting running loads to succeed")
+	}
+
+	var running, max int32
+	slow := func(key string) (interface{}, time.Time, error) {
+		if n := atomic.AddInt32(&running, 1); n > atomic.LoadInt32(&max) {
+			atomic.StoreInt32(&max, n)
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return key, time.Time{}, nil
+	}
+	c := NewLRUCache(20, WithLoader(slow), WithMaxInflight(2, BlockWhenFull))
+	for i := 0; i < 20; i++ {
+		go func(k string) {
+			_, err := c.GetWithRefresh(k, BlockOnStale)
+			done <- err
+		}(fmt.Sprint(i))
+	}
+	for i := 0; i < 20; i++ {
+		if err := <-done; err != nil {
+			t.Error("expecting blocked loads to succeed", err)
+		}
+	}
+	if max > 2 || c.Len() != 20 {
+		t.Error("expecting at most two loads at a time", max)
+	}
+
+	for _, n := range []int{0, -1} {
+		d := NewLRUCache(2, WithLoader(slow), WithMaxInflight(n, BlockWhenFull))
+		if v, err := d.GetWithRefresh("a", BlockOnStale); err != nil || v != "a" {
+			t.Error("expecting no limit", n, err)
+		}
+	}
+}
+
+func TestMissTracking(t *testing.T) {
+	t.Parallel()
+	if NewLRUCache(1).RecentMisses() != nil {
+		t.Error("expecting no tracking by default")
+	}
+	b := NewLRUCache(2, WithMissTracking(3))
+
+	b.Set("a", "va", time.Time{})
+	b.Set("s", "vs", time.Now().Add(-time.Second))
+	b.Get("a")
+	b.Get("x")
+	b.GetNotStale("s")
+	b.Get("y")
+	b.Get("z")
+	if m := b.RecentMisses(); fmt.Sprint(m) != "[s y z]" {
+		t.Error("expecting recent misses, oldest first", m)
+	}
+}
+
+func TestExpiryTieBreak(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(100, WithEvictionLog(100))
+
+	now := time.Now()
+	var keys []string
+	for i := 0; i < 50; i++ {
+		keys = append(keys, fmt.Sprint(i))
+		b.Set(keys[i], i, now)
+	}
+	b.Set("0", 0, now)
+	keys = append(keys[1:], "0")
+
+	b.ExpireNow(now.Add(time.Second))
+	for i, r := range b.RecentEvictions() {
+		if r.Key != keys[i] {
+			t.Fatal("expecting insertion order", i, r.Key)
+		}
+	}
+}
+
+func TestProactiveExpiry(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(10, WithProactiveExpiry(0.5, 2))
+
+	past := time.Now().Add(-time.Second)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		b.Set(k, k, past)
+	}
+	b.Set("e", "ve", time.Time{})
+	if b.Len() != 5 {
+		t.Error("expecting no reaping while sparse", b.Len())
+	}
+	b.Set("f", "vf", time.Time{})
+	if b.Len() != 4 {
+		t.Error("expecting bounded reaping under pressure", b.Len())
+	}
+	b.Set("f", "vf2", time.Time{})
+	if b.Len() != 4 {
+		t.Error("expecting no reaping on overwrite", b.Len())
+	}
+}
+
+func TestSelfCheck(t *testing.T) {
+	t.Parallel()
+	var errs []error
+	b := NewLRUCache(8, WithSelfCheck(2, func(err error) { errs = append(errs, err) }))
+
+	for i := 0; i < 4; i++ {
+		b.Set(fmt.Sprint(i), i, time.Now().Add(time.Hour))
+	}
+	b.Set("1", 1, time.Time{})
+	b.Del("0")
+	b.Del("1")
+	if len(errs) != 0 {
+		t.Fatal("expecting no errors", errs)
+	}
+
+	delete(b.table, "3")
+	b.Set("x", "vx", time.Time{})
+	if len(errs) != 0 {
+		t.Error("expecting checks only every two operations")
+	}
+	b.Set("y", "vy", time.Time{})
+	if len(errs) == 0 {
+		t.Error("expecting table out of sync to be reported")
+	}
+}
+
+// Evictions needed to reclaim `target` bytes from a cache full of
+// mostly small and a few large items.
+func evictionsToReclaim(target int, options ...Option) int {
+	reclaimed, evictions := 0, 0
+	onEvict := func(key string, value interface{}, reason EvictReason) {
+		reclaimed += value.(int)
+		evictions += 1
+	}
+	b := NewLRUCache(100, append(options, WithOnEvict(onEvict))...)
+	for i := 0; i < 100; i++ {
+		v := 1
+		if i%10 == 0 {
+			v = 100
+		}
+		b.Set(fmt.Sprint(i), v, time.Time{})
+	}
+	for i := 0; reclaimed < target; i++ {
+		b.Set(fmt.Sprint("new", i), 1, time.Time{})
+	}
+	return evictions
+}
+
+func TestSizeAwareEviction(t *testing.T) {
+	t.Parallel()
+	size := func(key string, value interface{}) int { return value.(int) }
+
+	lru := evictionsToReclaim(500)
+	sized := evictionsToReclaim(500, WithSizeAwareEviction(size, 16))
+	if sized >= lru {
+		t.Error("expecting fewer evictions", sized, lru)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3)
+
+	var calls int32
+	release := make(chan bool)
+	loader := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "va", nil
+	}
+	done := make(chan interface{})
+	for i := 0; i < 5; i++ {
+		go func() {
+			v, _ := b.Fetch("a", time.Hour, loader)
+			done <- v
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	for i := 0; i < 5; i++ {
+		if v := <-done; v != "va" {
+			t.Error("expecting loaded value", v)
+		}
+	}
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Error("expecting one load", calls)
+	}
+	if v, err := b.Fetch("a", time.Hour, loader); v != "va" || err != nil || atomic.LoadInt32(&calls) != 1 {
+		t.Error("expecting fresh value without a load")
+	}
+
+	b.Set("a", "old", time.Now().Add(-time.Second))
+	fail := errors.New("fail")
+	if _, err := b.Fetch("a", time.Hour, func() (interface{}, error) { return nil, fail }); err != fail {
+		t.Error("expecting loader error", err)
+	}
+	if v, err := b.Fetch("a", 0, func() (interface{}, error) { return "new", nil }); v != "new" || err != nil {
+		t.Error("expecting errors not to be cached", v, err)
+	}
+	if !b.lookup("a").expire.IsZero() {
+		t.Error("expecting no expiry for zero ttl")
+	}
+}
+
+func TestExportNDJSON(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3)
+
+	expire := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	b.Set("a", "va", time.Time{})
+	b.Set("b", 42, expire)
+
+	var buf bytes.Buffer
+	if err := b.ExportNDJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"key":"b","value":42,"expire":"2030-01-02T03:04:05Z"}` + "\n" +
+		`{"key":"a","value":"va"}` + "\n"
+	if buf.String() != want {
+		t.Error("expecting different output", buf.String())
+	}
+
+	b.Set("c", func() {}, time.Time{})
+	if err := b.ExportNDJSON(&buf); err == nil {
+		t.Error("expecting error for a value that can't be encoded")
+	}
+}
+
+func TestTTL(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3)
+
+	b.Set("a", "va", time.Time{})
+	b.Set("b", "vb", time.Now().Add(time.Hour))
+	b.Set("c", "vc", time.Now().Add(-time.Second))
+
+	if ttl, ok := b.TTL("a"); !ok || ttl != NoExpiry {
+		t.Error("expecting no expiry", ttl)
+	}
+	if ttl, ok := b.TTL("b"); !ok || ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Error("expecting an hour left", ttl)
+	}
+	if ttl, ok := b.TTL("c"); !ok || ttl > 0 {
+		t.Error("expecting stale item", ttl)
+	}
+	if _, ok := b.TTL("x"); ok {
+		t.Error("expecting missing key")
+	}
+	if k := b.MostRecentlyUsed(1); k[0] != "c" {
+		t.Error("expecting LRU order untouched", k)
+	}
+}
+
+func TestGetFresh(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3)
+
+	now := time.Now()
+	b.Set("a", "va", time.Time{})
+	b.Set("b", "vb", now.Add(time.Hour))
+	b.Set("c", "vc", now.Add(-time.Second))
+
+	if v, ok := b.GetFreshNow("a", now); !ok || v != "va" {
+		t.Error("expecting item without expiry")
+	}
+	if v, ok := b.GetFreshNow("b", now); !ok || v != "vb" {
+		t.Error("expecting fresh item")
+	}
+	if _, ok := b.GetFreshNow("c", now); ok || b.Len() != 3 {
+		t.Error("expecting stale item to be a miss and stay")
+	}
+	if k := b.MostRecentlyUsed(1); k[0] != "c" {
+		t.Error("expecting LRU order untouched", k)
+	}
+}
+
+func TestExpirySpread(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(100, WithExpirySpread(10*time.Second))
+
+	expire := time.Now().Add(time.Hour)
+	for i := 0; i < 100; i++ {
+		b.SetNow(fmt.Sprint(i), i, expire, time.Time{})
+	}
+	counts := make([]int, 10)
+	for i := 0; i < 100; i++ {
+		d := b.lookup(fmt.Sprint(i)).expire.Sub(expire)
+		if d < 0 || d >= 10*time.Second {
+			t.Fatal("expecting expiry within the window", d)
+		}
+		counts[d/time.Second] += 1
+	}
+	for _, n := range counts {
+		if n < 8 || n > 12 {
+			t.Error("expecting even spread", counts)
+			break
+		}
+	}
+
+	later := expire.Add(time.Minute)
+	b.Set("new", "v", later)
+	if !b.lookup("new").expire.Equal(later) {
+		t.Error("expecting a new batch to start unchanged")
+	}
+
+	// Later items of the batch are never moved earlier.
+	for i := 0; i < 100; i++ {
+		requested := later.Add(time.Duration(i) * 97 * time.Millisecond)
+		b.Set(fmt.Sprint(i), i, requested)
+		if got := b.lookup(fmt.Sprint(i)).expire; got.Before(requested) || got.Sub(requested) >= 10*time.Second {
+			t.Fatal("expecting expiry not earlier than requested", requested, got)
+		}
+	}
+}
+
+func TestStatsRemovals(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(2)
+
+	now := time.Now()
+	b.Set("a", "va", now.Add(-time.Second))
+	b.GetNotStale("a")
+	b.Set("b", "vb", now.Add(-time.Second))
+	b.Expire()
+	b.Set("c", "vc", time.Time{})
+	b.Set("c", "vc2", time.Time{})
+	b.Del("c")
+	b.Set("d", "vd", time.Time{})
+	b.Set("e", "ve", time.Time{})
+	b.Set("f", "vf", time.Time{})
+	b.Clear()
+
+	r := b.Stats().Removals
+	if r[EvictStale] != 1 || r[EvictExpired] != 1 || r[EvictDeleted] != 1 || r[EvictCapacity] != 1 || r[EvictCleared] != 2 {
+		t.Error("expecting removals by reason", r)
+	}
+}
+
+func TestWriteBehind(t *testing.T) {
+	t.Parallel()
+	flushed := make(chan []KeyValue, 10)
+	record := func(batch []KeyValue) error {
+		flushed <- batch
+		return nil
+	}
+
+	b := NewLRUCache(3, WithWriteBehind(time.Hour, 2, record))
+	b.Set("a", "va", time.Time{})
+	b.Set("a", "va2", time.Time{})
+	b.Set("b", "vb", time.Time{})
+	if batch := <-flushed; len(batch) != 2 || batch[0] != (KeyValue{"a", "va2"}) || batch[1].Key != "b" {
+		t.Error("expecting a full batch to be flushed", batch)
+	}
+	b.Set("c", "vc", time.Time{})
+	b.Close()
+	if batch := <-flushed; len(batch) != 1 || batch[0].Key != "c" {
+		t.Error("expecting c flushed by close at the latest", batch)
+	}
+
+	var calls int32
+	failing := func(batch []KeyValue) error {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			return errors.New("store down")
+		}
+		return record(batch)
+	}
+	c := NewLRUCache(3, WithWriteBehind(time.Millisecond, 10, failing))
+	defer c.Close()
+	c.Set("x", "vx", time.Time{})
+	if batch := <-flushed; len(batch) != 1 || batch[0].Key != "x" || atomic.LoadInt32(&calls) != 3 {
+		t.Error("expecting failed flushes to be retried", batch)
+	}
+	if c.PendingFlush() != 0 {
+		t.Error("expecting nothing pending")
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(2)
+
+	now := time.Now()
+	b.Set("a", 1, now.Add(time.Second))
+	if _, ok := b.Acquire("x"); ok {
+		t.Error("expecting missing key")
+	}
+	h, ok := b.Acquire("a")
+	if !ok || h.Value() != 1 {
+		t.Fatal("expecting handle")
+	}
+
+	b.Set("b", 2, time.Time{})
+	b.Get("b")
+	b.Set("c", 3, time.Time{})
+	if _, ok := b.GetQuiet("a"); !ok {
+		t.Error("expecting pinned item not to be evicted")
+	}
+	b.ExpireNow(now.Add(time.Hour))
+	if _, ok := b.GetNotStaleNow("a", now.Add(time.Hour)); ok || b.Len() != 2 {
+		t.Error("expecting pinned item to be stale but not evicted")
+	}
+
+	h.SetValue(h.Value().(int) + 1)
+	h.SetExpire(now.Add(2 * time.Hour))
+	if v, _ := b.GetQuiet("a"); v != 1 {
+		t.Error("expecting changes to wait for release")
+	}
+	h.Release()
+	if v, _ := b.GetQuiet("a"); v != 2 {
+		t.Error("expecting changes applied on release", v)
+	}
+	if r := rec(func() { h.Value() }); r != 1 {
+		t.Error("expecting panic after release")
+	}
+	if b.ExpireNow(now.Add(3*time.Hour)) != 1 {
+		t.Error("expecting released item to expire again")
+	}
+
+	h, _ = b.Acquire("c")
+	b.Del("c")
+	b.Set("c", 4, time.Time{})
+	h.SetValue(5)
+	h.Release()
+	if v, _ := b.GetQuiet("c"); v != 4 {
+		t.Error("expecting changes to a removed item to be lost", v)
+	}
+}
+
+func TestGhostList(t *testing.T) {
+	t.Parallel()
+	if NewLRUCache(1).GhostHitRate() != 0 {
+		t.Error("expecting no rate without a ghost list")
+	}
+	b := NewLRUCache(2, WithGhostList(2))
+
+	b.Set("a", "va", time.Time{})
+	b.Set("b", "vb", time.Time{})
+	b.Set("c", "vc", time.Time{}) // pushes out "a"
+	b.Del("b")                    // not a ghost
+	b.Get("a")
+	b.Get("b")
+	if r := b.GhostHitRate(); r != 0.5 {
+		t.Error("expecting half of the misses to be ghost hits", r)
+	}
+
+	b.Set("d", "vd", time.Time{})
+	b.Set("e", "ve", time.Time{}) // pushes out "c"
+	b.Set("f", "vf", time.Time{}) // pushes out "d", forgets "a"
+	b.Get("a")
+	b.Get("d")
+	if r := b.GhostHitRate(); r != 0.5 {
+		t.Error("expecting old ghosts to be forgotten", r)
+	}
+}
+
+func TestSetCtx(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if NewLRUCache(1).SetCtx(ctx, "a", 1, time.Time{}) != nil {
+		t.Error("expecting context to be ignored without write-through")
+	}
+
+	stored := map[string]interface{}{}
+	block := make(chan struct{})
+	b := NewLRUCache(2, WithWriteThrough(func(ctx context.Context, key string, value interface{}, expire time.Time) error {
+		if key == "slow" {
+			<-block
+		}
+		if key == "bad" {
+			return errors.New("store failed")
+		}
+		stored[key] = value
+		return nil
+	}))
+	defer close(block)
+
+	if b.SetCtx(context.Background(), "a", 1, time.Time{}) != nil || stored["a"] != 1 {
+		t.Error("expecting item to be written through")
+	}
+	if v, _ := b.GetQuiet("a"); v != 1 {
+		t.Error("expecting stored item in the cache")
+	}
+	if b.Set("bad", 2, time.Time{}) == nil {
+		t.Error("expecting store error")
+	}
+	if b.SetCtx(ctx, "b", 3, time.Time{}) != context.Canceled {
+		t.Error("expecting canceled context to fail")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if b.SetCtx(ctx, "slow", 4, time.Time{}) != context.DeadlineExceeded {
+		t.Error("expecting deadline while the store blocks")
+	}
+	for _, key := range []string{"bad", "b", "slow"} {
+		if _, ok := b.GetQuiet(key); ok {
+			t.Error("expecting failed item not in the cache", key)
+		}
+	}
+}
+
+func TestIncrementCapped(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(2)
+	window := time.Now().Add(time.Hour)
+
+	for i := int64(1); i <= 3; i++ {
+		if n, capped := b.IncrementCapped("ip", 1, 3, window); n != i || capped {
+			t.Error("expecting counter below the cap", n, capped)
+		}
+	}
+	if n, capped := b.IncrementCapped("ip", 1, 3, window.Add(time.Hour)); n != 3 || !capped {
+		t.Error("expecting counter clamped at the cap", n, capped)
+	}
+	if ttl, _ := b.TTL("ip"); ttl > time.Hour {
+		t.Error("expecting counter to keep its window", ttl)
+	}
+
+	b.Set("ip", "text", time.Time{})
+	if b.IncrementInt64("ip", 5, time.Time{}) != 5 {
+		t.Error("expecting non-counter to be replaced")
+	}
+	b.Set("old", int64(7), time.Now().Add(-time.Second))
+	if b.IncrementInt64("old", 1, time.Time{}) != 1 {
+		t.Error("expecting stale counter to restart")
+	}
+	b.Set("big", int64(math.MaxInt64-1), time.Time{})
+	if b.IncrementInt64("big", 10, time.Time{}) != math.MaxInt64 {
+		t.Error("expecting counter to saturate")
+	}
+	b.Set("big", int64(math.MinInt64+1), time.Time{})
+	if b.IncrementInt64("big", math.MinInt64, time.Time{}) != math.MinInt64 {
+		t.Error("expecting counter to saturate downwards")
+	}
+
+	// The expiry isn't spread again on every increment.
+	c := NewLRUCache(2, WithExpirySpread(10*time.Second))
+	c.Set("other", 1, window)
+	c.IncrementInt64("n", 1, window)
+	first := c.lookup("n").expire
+	for i := 0; i < 5; i++ {
+		c.IncrementInt64("n", 1, window)
+	}
+	if !c.lookup("n").expire.Equal(first) {
+		t.Error("expecting counter to keep its expiry", first, c.lookup("n").expire)
+	}
+}
+
+func TestEvictionOrder(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(4)
+	past := time.Now().Add(-time.Minute)
+
+	b.Set("a", 1, time.Time{})
+	b.Set("b", 2, time.Time{})
+	b.Set("c", 3, past.Add(time.Second))
+	b.Set("d", 4, past)
+	b.Get("a")
+	if o := fmt.Sprint(b.EvictionOrder()); o != "[d c b a]" {
+		t.Error("expecting expired first, then least recently used", o)
+	}
+
+	h, _ := b.Acquire("b")
+	defer h.Release()
+	b.Set("e", 5, time.Time{}) // evicts "d"
+	if o := fmt.Sprint(b.EvictionOrder()); o != "[c a e]" {
+		t.Error("expecting evicted and pinned items left out", o)
+	}
+}
+
+func TestWarmFrom(t *testing.T) {
+	t.Parallel()
+	peer := NewLRUCache(5)
+	peer.Set("old", 1, time.Now().Add(-time.Second))
+	peer.Set("c", "vc", time.Now().Add(2*time.Hour))
+	peer.Set("b", "vb", time.Time{})
+	peer.Set("a", "va", time.Now().Add(time.Hour))
+	peer.Set("x", "peer", time.Time{})
+	var buf bytes.Buffer
+	if err := peer.ExportNDJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewLRUCache(4)
+	b.Set("x", "mine", time.Time{})
+	loaded, skipped, err := b.WarmFrom(&buf)
+	if err != nil || loaded != 2 || skipped != 3 {
+		t.Error("expecting the most recent fresh items to fit", loaded, skipped, err)
+	}
+	if v, _ := b.Get("x"); v != "mine" {
+		t.Error("expecting existing item to be kept", v)
+	}
+	if _, ok := b.Get("c"); ok {
+		t.Error("expecting items past the capacity to be skipped")
+	}
+	if ttl, _ := b.TTL("a"); ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Error("expecting expiry to be preserved", ttl)
+	}
+	if o := fmt.Sprint(b.EvictionOrder()); o != "[b a x]" {
+		t.Error("expecting warmed items behind existing ones, in stream order", o)
+	}
+	if n := b.ExpireNow(time.Now().Add(90 * time.Minute)); n != 1 {
+		t.Error("expecting warmed items in the expiry heap", n)
+	}
+
+	// Items skipped don't leave anything behind in the free entries.
+	b = NewLRUCache(2, WithSharedBudget(NewBudget(5), func(key string, value interface{}) int { return 10 }))
+	if loaded, skipped, _ := b.WarmFrom(strings.NewReader(`{"key":"a","value":"va"}`)); loaded != 0 || skipped != 1 {
+		t.Error("expecting item over the budget skipped", loaded, skipped)
+	}
+	for el := b.freeList.Front(); el != nil; el = el.Next() {
+		if e := el.Value.(*entry); e.key != "" || e.value != nil {
+			t.Error("expecting free entry left clear", e.key)
+		}
+	}
+
+	// Drained while the stream is read.
+	b = NewLRUCache(4)
+	r, w := io.Pipe()
+	go func() {
+		w.Write([]byte(`{"key":"a","value":1}` + "\n"))
+		b.Drain()
+		w.Write([]byte(`{"key":"b","value":2}` + "\n"))
+		w.Close()
+	}()
+	if _, _, err := b.WarmFrom(r); err != ErrDraining || b.Len() != 0 {
+		t.Error("expecting nothing warmed once draining", err, b.Len())
+	}
+}
+
+func TestIsExpired(t *testing.T) {
+	t.Parallel()
+	expire := time.Now()
+	tests := []struct {
+		now       time.Duration // relative to expire
+		tolerance time.Duration
+		expired   bool
+	}{
+		{-time.Second, 0, false},
+		{0, 0, false},
+		{time.Nanosecond, 0, true},
+		{0, time.Second, false},
+		{time.Second, time.Second, false},
+		{time.Second + time.Nanosecond, time.Second, true},
+	}
+	for _, tt := range tests {
+		b := NewLRUCache(1, WithClockSkewTolerance(tt.tolerance))
+		now := expire.Add(tt.now)
+		if b.isExpired(&entry{expire: expire}, now) != tt.expired {
+			t.Error("expecting expired to be", tt.expired, tt.now, tt.tolerance)
+		}
+		if b.isExpired(&entry{}, now) {
+			t.Error("expecting item without expiry to never expire")
+		}
+
+		b.Set("a", 1, expire)
+		if _, ok := b.GetFreshNow("a", now); ok == tt.expired {
+			t.Error("expecting GetFreshNow to agree", tt.now, tt.tolerance)
+		}
+		if keys := b.ExpiredKeys(now); (len(keys) == 1) != tt.expired {
+			t.Error("expecting ExpiredKeys to agree", tt.now, tt.tolerance)
+		}
+		if _, ok := b.GetNotStaleNow("a", now); ok == tt.expired {
+			t.Error("expecting GetNotStaleNow to agree", tt.now, tt.tolerance)
+		}
+		b.Set("a", 1, expire)
+		if (b.ExpireNow(now) == 1) != tt.expired {
+			t.Error("expecting ExpireNow to agree", tt.now, tt.tolerance)
+		}
+	}
+
+	b := NewLRUCache(1)
+	b.Set("a", 1, time.Time{})
+	if _, ok := b.GetNotStale("a"); !ok {
+		t.Error("expecting item without expiry to be fresh")
+	}
+}
+
+func TestValueSlots(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(2, WithValueSlots(4))
+
+	v := []byte("abc")
+	if b.Set("a", v, time.Time{}) != nil {
+		t.Error("expecting value to fit")
+	}
+	v[0] = 'x'
+	if got, _ := b.Get("a"); string(got.([]byte)) != "abc" {
+		t.Error("expecting value to be copied", got)
+	}
+	if b.Set("b", []byte("abcde"), time.Time{}) != ErrValueTooLarge {
+		t.Error("expecting too large value to be rejected")
+	}
+	if b.Set("b", "abc", time.Time{}) != ErrValueTooLarge {
+		t.Error("expecting non []byte value to be rejected")
+	}
+	if b.Len() != 1 {
+		t.Error("expecting rejected values not stored")
+	}
+
+	got, _ := b.Get("a")
+	b.Set("a", append(got.([]byte), 'd'), time.Time{})
+	if got, _ := b.Get("a"); string(got.([]byte)) != "abcd" {
+		t.Error("expecting slot to be reused", got)
+	}
+
+	h, _ := b.Acquire("a")
+	h.SetValue([]byte("toolong"))
+	h.Release()
+	if got, _ := b.Get("a"); string(got.([]byte)) != "abcd" {
+		t.Error("expecting handle value that doesn't fit to be dropped", got)
+	}
+}
+
+func TestMaxEvictionScan(t *testing.T) {
+	t.Parallel()
+	vetoed := map[string]bool{"a": true, "b": true}
+	canEvict := func(key string, value interface{}) bool { return !vetoed[key] }
+	b := NewLRUCache(3, WithCanEvict(canEvict), WithMaxEvictionScan(2))
+
+	b.Set("a", 1, time.Time{})
+	b.Set("b", 2, time.Time{})
+	b.Set("c", 3, time.Time{})
+	if b.Set("d", 4, time.Time{}) != ErrCacheFull {
+		t.Error("expecting scan to give up after two vetoed items")
+	}
+	if n := b.Stats().EvictionScans; n != 2 {
+		t.Error("expecting two items scanned", n)
+	}
+
+	delete(vetoed, "b")
+	if b.Set("d", 4, time.Time{}) != nil {
+		t.Error("expecting item within the limit to be evicted")
+	}
+	if _, ok := b.GetQuiet("b"); ok {
+		t.Error("expecting b evicted")
+	}
+	if n := b.Stats().EvictionScans; n != 4 {
+		t.Error("expecting scans counted", n)
+	}
+
+	b = NewLRUCache(3, WithCanEvict(canEvict))
+	vetoed["b"] = true
+	b.Set("a", 1, time.Time{})
+	b.Set("b", 2, time.Time{})
+	b.Set("c", 3, time.Time{})
+	if b.Set("d", 4, time.Time{}) != nil || b.Stats().EvictionScans != 3 {
+		t.Error("expecting unbounded scan to reach c")
+	}
+}
+
+func TestWindowedHitRate(t *testing.T) {
+	t.Parallel()
+	if NewLRUCache(1).WindowedHitRate(time.Second) != 0 {
+		t.Error("expecting no rate without a window")
+	}
+	b := NewLRUCache(2, WithHitRateWindow(100*time.Millisecond))
+
+	b.Get("a")
+	b.Get("a")
+	b.Set("a", 1, time.Time{})
+	b.Get("a")
+	b.GetNotStale("a")
+	if r := b.WindowedHitRate(100 * time.Millisecond); r != 0.5 {
+		t.Error("expecting half of the lookups to hit", r)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	b.Get("a")
+	if r := b.WindowedHitRate(100 * time.Millisecond); r != 1 {
+		t.Error("expecting old misses to leave the window", r)
+	}
+}
+
+func TestTouchOrSet(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(2)
+	now := time.Now()
+
+	if existed, err := b.TouchOrSet("a", 1, now.Add(time.Minute)); existed || err != nil {
+		t.Error("expecting missing key to be added", err)
+	}
+	b.Set("b", 2, now.Add(time.Hour))
+	if existed, _ := b.TouchOrSet("a", 3, now.Add(2*time.Hour)); !existed {
+		t.Error("expecting existing key to be touched")
+	}
+	if v, _ := b.GetQuiet("a"); v != 1 {
+		t.Error("expecting value to be kept", v)
+	}
+	if ttl, _ := b.TTL("a"); ttl <= time.Hour {
+		t.Error("expecting expiry to be extended", ttl)
+	}
+	if keys := b.ExpiredKeys(now.Add(90 * time.Minute)); len(keys) != 1 || keys[0] != "b" {
+		t.Error("expecting expiry heap to follow", keys)
+	}
+	if o := fmt.Sprint(b.EvictionOrder()); o != "[b a]" {
+		t.Error("expecting touched key to be promoted", o)
+	}
+
+	b.TouchOrSet("a", 1, time.Time{})
+	if b.ExpireNow(now.Add(3*time.Hour)) != 1 || b.Len() != 1 {
+		t.Error("expecting cleared expiry to never expire")
+	}
+
+	b.Set("c", 4, now.Add(-time.Second))
+	if existed, _ := b.TouchOrSet("c", 5, time.Time{}); existed {
+		t.Error("expecting stale key to be replaced")
+	}
+	if v, _ := b.GetQuiet("c"); v != 5 {
+		t.Error("expecting new value for stale key", v)
+	}
+
+	b.Drain()
+	if existed, err := b.TouchOrSet("d", 6, time.Time{}); existed || err != ErrDraining {
+		t.Error("expecting the failed Set reported", err)
+	}
+}
+
+func TestUnsafeRange(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3)
+	expire := time.Now().Add(time.Hour)
+	b.Set("a", 1, time.Time{})
+	b.Set("b", 2, expire)
+	b.SetLazy("c", func() interface{} { return 3 }, time.Time{})
+
+	var got []string
+	b.UnsafeRange(func(key string, value interface{}, e time.Time) {
+		got = append(got, fmt.Sprint(key, value, e.Equal(expire)))
+	})
+	if fmt.Sprint(got) != "[c<nil> false b2 true a1 false]" {
+		t.Error("expecting live items, unread lazy value as nil", got)
+	}
+}
+
+func TestExpiryClasses(t *testing.T) {
+	t.Parallel()
+	if NewLRUCache(1).SetClass("a", 1, time.Time{}, 1) != ErrNoSuchClass {
+		t.Error("expecting single class by default")
+	}
+	b := NewLRUCache(4, WithExpiryClasses(0, 0), WithSelfCheck(1, func(err error) { t.Error(err) }))
+	now := time.Now()
+
+	b.Set("a", 1, now.Add(time.Second))
+	b.SetClass("b", 2, now.Add(2*time.Second), 1)
+	b.SetClass("c", 3, now.Add(3*time.Second), 1)
+	b.Set("c", 4, now.Add(time.Minute)) // keeps class 1
+	if n := b.ExpireClassNow(1, now.Add(5*time.Second)); n != 1 {
+		t.Error("expecting only class 1 expired", n)
+	}
+	if _, ok := b.GetQuiet("a"); !ok {
+		t.Error("expecting class 0 untouched")
+	}
+	if n := b.ExpireClassNow(1, now.Add(2*time.Minute)); n != 1 {
+		t.Error("expecting overwritten item to stay in its class", n)
+	}
+
+	b.SetClass("d", 5, now.Add(-2*time.Second), 1)
+	b.Set("e", 6, now.Add(-time.Second))
+	b.Set("f", 7, time.Time{})
+	b.Set("g", 8, time.Time{}) // evicts "d", the earliest in any class
+	if _, ok := b.GetQuiet("d"); ok {
+		t.Error("expecting earliest expired item evicted first")
+	}
+	if next, _ := b.NextExpiry(); !next.Equal(now.Add(-time.Second)) {
+		t.Error("expecting next expiry across classes", next)
+	}
+	if b.ExpireNow(now.Add(time.Hour)) != 2 || b.Len() != 2 {
+		t.Error("expecting Expire to cover all classes")
+	}
+}
+
+func TestExpiryClassJanitor(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(2, WithExpiryClasses(0, time.Millisecond))
+	defer b.Close()
+
+	b.Set("slow", 1, time.Now().Add(-time.Second))
+	b.SetClass("fast", 2, time.Now().Add(-time.Second), 1)
+	deadline := time.Now().Add(time.Second)
+	for b.Len() == 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := b.GetQuiet("fast"); ok {
+		t.Error("expecting class janitor to expire its class")
+	}
+	if _, ok := b.GetQuiet("slow"); !ok {
+		t.Error("expecting other classes left alone")
+	}
+}
+
+func TestGetOrdered(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3)
+	b.Set("a", 1, time.Time{})
+	b.Set("b", 2, time.Time{})
+	b.SetLazy("c", func() interface{} { return 3 }, time.Time{})
+
+	values, found := b.GetOrdered([]string{"b", "x", "c", "b", "a"})
+	if fmt.Sprint(values, found) != "[2 <nil> 3 2 1] [true false true true true]" {
+		t.Error("expecting results in the order of the keys", values, found)
+	}
+	if o := fmt.Sprint(b.EvictionOrder()); o != "[c b a]" {
+		t.Error("expecting keys to be touched in order", o)
+	}
+}
+
+func TestDemotion(t *testing.T) {
+	t.Parallel()
+	calls := 0
+	demote := func(key string, value interface{}) (string, interface{}, time.Time, bool) {
+		calls += 1
+		return key, fmt.Sprint("small", value), time.Time{}, true
+	}
+	b := NewLRUCache(3, WithDemotion(2, demote))
+	b.Set("a", 1, time.Time{})
+	b.Set("b", 2, time.Time{})
+	b.Set("c", 3, time.Time{})
+	b.Set("d", 4, time.Time{})
+	if calls != 1 || b.Stats().Demotions != 1 {
+		t.Error("expecting one demotion per set", calls)
+	}
+	if o := fmt.Sprint(b.EvictionOrder()); o != "[c a d]" {
+		t.Error("expecting the next oldest item evicted", o)
+	}
+	if v, _ := b.GetQuiet("a"); v != "small1" {
+		t.Error("expecting demoted value", v)
+	}
+	b.Set("e", 5, time.Time{})
+	if o := fmt.Sprint(b.EvictionOrder()); calls != 2 || o != "[d c e]" {
+		t.Error("expecting demoted item evicted in turn", calls, o)
+	}
+
+	summarize := func(key string, value interface{}) (string, interface{}, time.Time, bool) {
+		return "summary:" + key, len(fmt.Sprint(value)), time.Time{}, key != "keep"
+	}
+	b = NewLRUCache(2, WithDemotion(1, summarize))
+	b.Set("a", "long value", time.Time{})
+	b.Set("keep", 1, time.Time{})
+	b.Set("c", 2, time.Time{})
+	if v, ok := b.GetQuiet("summary:a"); !ok || v != 10 || b.Len() != 2 {
+		t.Error("expecting item rekeyed to its summary", v)
+	}
+	if _, ok := b.GetQuiet("keep"); ok {
+		t.Error("expecting the next item evicted")
+	}
+	b.Set("a", "again", time.Time{}) // "summary:a" is at depth 1
+	if _, ok := b.GetQuiet("summary:a"); ok {
+		t.Error("expecting item at max depth evicted")
+	}
+
+	b = NewLRUCache(1, WithDemotion(1, summarize))
+	b.Set("keep", 1, time.Time{})
+	b.Set("c", 2, time.Time{})
+	if o := fmt.Sprint(b.EvictionOrder()); o != "[c]" || b.Stats().Demotions != 0 {
+		t.Error("expecting refused demotion to evict", o)
+	}
+}
+
+func TestStatsSampling(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(1)
+	b.Set("a", 1, time.Time{})
+	b.Get("a")
+	b.GetNotStale("b")
+	if s := b.Stats(); s.Hits != 1 || s.Misses != 1 || s.Sampled {
+		t.Error("expecting exact counts without sampling", s)
+	}
+
+	b = NewLRUCache(1, WithStatsSampling(16))
+	b.Set("a", 1, time.Time{})
+	for i := 0; i < 160000; i++ {
+		if i%4 == 0 {
+			b.Get("a")
+		} else {
+			b.Get("b")
+		}
+	}
+	s := b.Stats()
+	// 10000 samples, the estimates are within a few percent.
+	if !s.Sampled || s.Hits < 36000 || s.Hits > 44000 || s.Misses < 114000 || s.Misses > 126000 {
+		t.Error("expecting estimates close to the real counts", s.Hits, s.Misses)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3)
+	now := time.Now()
+	b.Set("a", 1, now.Add(time.Second))
+	b.Set("b", 2, time.Time{})
+
+	done := b.Drain()
+	if b.Set("c", 3, time.Time{}) != ErrDraining || b.Append("c", 3, time.Time{}) != 0 {
+		t.Error("expecting writes to be rejected")
+	}
+	if v, ok := b.Get("a"); !ok || v != 1 {
+		t.Error("expecting reads to work")
+	}
+	b.ExpireNow(now.Add(time.Minute))
+	if b.Drained() {
+		t.Error("expecting cache not empty yet")
+	}
+	b.Del("b")
+	select {
+	case <-done:
+	default:
+		t.Error("expecting channel closed once empty")
+	}
+	if !b.Drained() || b.Drain() != done {
+		t.Error("expecting Drain to be idempotent")
+	}
+
+	b = NewLRUCache(1)
+	select {
+	case <-b.Drain():
+	default:
+		t.Error("expecting empty cache drained at once")
+	}
+}
+
+func TestKeysInto(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3)
+	b.Set("a", 1, time.Time{})
+	b.Set("b", 2, time.Time{})
+
+	buf := make([]string, 5, 10)
+	keys := b.KeysInto(buf)
+	if fmt.Sprint(keys) != "[b a]" || &keys[0] != &buf[0] {
+		t.Error("expecting keys in dst", keys)
+	}
+	b.Set("c", 3, time.Time{})
+	if again := b.KeysInto(keys); len(again) != 3 || &again[0] != &buf[0] {
+		t.Error("expecting backing array reused", again)
+	}
+	if keys := b.KeysInto(make([]string, 0, 1)); fmt.Sprint(keys) != "[c b a]" {
+		t.Error("expecting dst to grow", keys)
+	}
+}
+
+// Hit rate of a hot set read in bursts, with scans of one-off keys
+// larger than the cache in between.
+func mixedWorkloadHitRate(b *LRUCache) float64 {
+	r := rand.New(rand.NewSource(1))
+	return workload.HitRate(b, 20*350, func(i int) (string, bool) {
+		round, j := i/350, i%350
+		if j < 200 {
+			return fmt.Sprint("hot", r.Intn(40)), true
+		}
+		return fmt.Sprint("scan", round, "-", j-200), true
+	})
+}
+
+func TestSLRU(t *testing.T) {
+	t.Parallel()
+	var checkErr error
+	b := NewLRUCache(4, WithSLRU(0.5), WithSelfCheck(1, func(err error) { checkErr = err }))
+
+	b.Set("a", 1, time.Time{})
+	b.Set("b", 2, time.Time{})
+	b.Set("c", 3, time.Time{})
+	b.Set("d", 4, time.Time{})
+	b.Get("a")
+	b.Get("b")
+	b.Set("e", 5, time.Time{})
+	if _, ok := b.GetQuiet("c"); ok {
+		t.Error("expecting probationary item evicted")
+	}
+	// d is promoted, a falls back on probation.
+	b.Get("d")
+	b.Set("f", 6, time.Time{})
+	if keys := fmt.Sprint(b.Keys()); keys != "[d b f a]" {
+		t.Error("expecting protected items first", keys)
+	}
+	b.Set("g", 7, time.Time{})
+	if keys := fmt.Sprint(b.Keys()); keys != "[d b g f]" {
+		t.Error("expecting demoted item evicted", keys)
+	}
+	b.Del("b")
+	b.Del("d")
+	b.Get("g")
+	if keys := fmt.Sprint(b.Keys()); keys != "[g f]" {
+		t.Error("expecting segments kept on delete", keys)
+	}
+	if checkErr != nil {
+		t.Error("expecting consistent segments", checkErr)
+	}
+
+	plain := mixedWorkloadHitRate(NewLRUCache(100))
+	segmented := mixedWorkloadHitRate(NewLRUCache(100, WithSLRU(0.8)))
+	if segmented < plain+0.1 {
+		t.Error("expecting better hit rate under scans", plain, segmented)
+	}
+}
+
+func TestFullnessCallback(t *testing.T) {
+	t.Parallel()
+	changes := make(chan bool, 10)
+	var b *LRUCache
+	b = NewLRUCache(2, WithFullnessCallback(func(full bool) {
+		b.Len() // may use the cache
+		changes <- full
+	}))
+	expect := func(full bool) {
+		select {
+		case got := <-changes:
+			if got != full {
+				t.Error("expecting fullness", full, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expecting fullness callback", full)
+		}
+	}
+
+	b.Set("a", 1, time.Time{})
+	b.Set("b", 2, time.Time{})
+	expect(true)
+	b.Set("c", 3, time.Time{})
+	b.Set("d", 4, time.Time{})
+	b.Del("d")
+	expect(false)
+	b.Set("e", 5, time.Time{})
+	expect(true)
+	b.Clear()
+	expect(false)
+	select {
+	case full := <-changes:
+		t.Error("expecting only transitions reported", full)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// Hit rate of Zipf distributed keys, with one-off keys mixed in.
+func zipfWorkloadHitRate(b *LRUCache) float64 {
+	r := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(r, 1.1, 1, 10000)
+	return workload.HitRate(b, 50000, func(i int) (string, bool) {
+		k := fmt.Sprint("z", zipf.Uint64())
+		if i%2 == 1 {
+			k = fmt.Sprint("churn", i)
+		}
+		return k, true
+	})
+}
+
+func TestAdmissionFilter(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(2, WithAdmissionFilter())
+
+	b.Set("a", 1, time.Time{})
+	b.Set("b", 2, time.Time{})
+	b.Get("a")
+	b.Get("b")
+	if err := b.Set("c", 3, time.Time{}); err != ErrRejected {
+		t.Error("expecting cold key rejected", err)
+	}
+	if err := b.Set("a", 4, time.Time{}); err != nil {
+		t.Error("expecting overwrite admitted", err)
+	}
+	b.Get("d")
+	b.Get("d")
+	b.Get("d")
+	if err := b.Set("d", 5, time.Time{}); err != nil {
+		t.Error("expecting hot key admitted", err)
+	}
+	if _, ok := b.GetQuiet("d"); !ok {
+		t.Error("expecting admitted key in the cache")
+	}
+	if s := b.Stats(); s.Rejections != 1 {
+		t.Error("expecting one rejection", s.Rejections)
+	}
+
+	plain := zipfWorkloadHitRate(NewLRUCache(100))
+	filtered := zipfWorkloadHitRate(NewLRUCache(100, WithAdmissionFilter()))
+	if filtered < plain*1.1 {
+		t.Error("expecting better hit rate on a Zipf workload", plain, filtered)
+	}
+}
+
+func TestMoveEntry(t *testing.T) {
+	t.Parallel()
+	a := NewLRUCache(10)
+	b := NewLRUCache(1, WithCanEvict(func(key string, value interface{}) bool { return false }))
+
+	expire := time.Now().Add(time.Hour)
+	a.Set("x", 1, expire)
+	a.Set("y", 2, time.Time{})
+	if !a.MoveEntry(b, "x") {
+		t.Error("expecting item moved")
+	}
+	ttl, _ := b.TTL("x")
+	if v, ok := b.GetQuiet("x"); !ok || v.(int) != 1 || ttl <= 59*time.Minute {
+		t.Error("expecting value and expiry kept", v, ok)
+	}
+	if _, ok := a.GetQuiet("x"); ok {
+		t.Error("expecting item gone from the source")
+	}
+	if a.MoveEntry(b, "y") || a.Len() != 1 {
+		t.Error("expecting item kept when the destination is full")
+	}
+	if a.MoveEntry(b, "z") || a.MoveEntry(a, "y") {
+		t.Error("expecting nothing to move")
+	}
+
+	// Moves in both directions must not deadlock.
+	a, b = NewLRUCache(100), NewLRUCache(100)
+	for i := 0; i < 50; i++ {
+		a.Set(fmt.Sprint(i), i, time.Time{})
+	}
+	done := make(chan struct{})
+	for g := 0; g < 4; g++ {
+		go func(g int) {
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprint((i + g) % 50)
+				if g%2 == 0 {
+					a.MoveEntry(b, key)
+				} else {
+					b.MoveEntry(a, key)
+				}
+			}
+			done <- struct{}{}
+		}(g)
+	}
+	go func() {
+		for i := 0; i < 100; i++ {
+			b.MoveTo(a, func(key string) bool { return true })
+		}
+		done <- struct{}{}
+	}()
+	for g := 0; g < 5; g++ {
+		<-done
+	}
+	if a.Len()+b.Len() != 50 {
+		t.Error("expecting every item in exactly one cache", a.Len(), b.Len())
+	}
+}
+
+func TestMovePinned(t *testing.T) {
+	t.Parallel()
+	a, b, c := NewLRUCache(2), NewLRUCache(2), NewLRUCache(2)
+	a.Set("x", 1, time.Now().Add(time.Hour))
+	h, _ := a.Acquire("x")
+	if !a.MoveEntry(b, "x") || !b.MoveEntry(c, "x") {
+		t.Fatal("expecting item moved")
+	}
+	c.Set("y", 2, time.Time{})
+	c.Set("z", 3, time.Time{})
+	if _, ok := c.GetQuiet("x"); !ok {
+		t.Error("expecting the item to stay pinned")
+	}
+	h.SetValue(4)
+	h.Release()
+	if v, _ := c.GetQuiet("x"); v != 4 || len(a.forwards) != 0 || len(b.forwards) != 0 {
+		t.Error("expecting the handle to follow the item", v)
+	}
+	c.Set("w", 5, time.Time{})
+	if _, ok := c.GetQuiet("x"); ok {
+		t.Error("expecting the item unpinned")
+	}
+}
+
+func TestDiagnosticsBound(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(10, WithMissTracking(1000), WithGhostList(1000), WithEvictionLog(1000),
+		WithDiagnosticsBound(100, 16))
+
+	b.Set("a", 1, time.Time{})
+	b.Get("b")
+	if d := b.Diagnostics(); d.Truncated() {
+		t.Error("expecting nothing lost yet", d)
+	}
+
+	long := strings.Repeat("x", 17)
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprint("flood", i)
+		if i%2 == 1 {
+			key = long + key
+		}
+		b.Get(key)
+		b.Set(key, i, time.Time{})
+	}
+	if n := len(b.RecentMisses()); n != 100 {
+		t.Error("expecting miss log bounded", n)
+	}
+	if n := len(b.RecentEvictions()); n != 100 {
+		t.Error("expecting eviction log bounded", n)
+	}
+	if len(b.ghost.keys) != 100 || len(b.ghost.count) > 100 {
+		t.Error("expecting ghost list bounded", len(b.ghost.count))
+	}
+	for _, key := range b.RecentMisses() {
+		if len(key) > 16 {
+			t.Error("expecting long keys dropped", key)
+		}
+	}
+
+	d := b.Diagnostics()
+	for _, s := range []DiagnosticStats{d.Misses, d.Ghost, d.Evictions} {
+		if !s.Truncated() || s.Dropped < 4990 || s.Overwritten == 0 {
+			t.Error("expecting losses counted", s)
+		}
+	}
+	if !d.Truncated() {
+		t.Error("expecting truncation reported")
+	}
+}
@@ -0,0 +1,80 @@
+package lrucache
+
+import (
+	"sync"
+)
+
+// Fixed size ring buffer of the most recently missed keys, with its
+// own lock like evictionLog.
+type missLog struct {
+	lock sync.Mutex
+	keys []string
+	next int // slot to be overwritten by the next key
+	full bool
+	diagBound
+}
+
+func (l *missLog) add(key string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if !l.fits(key) {
+		return
+	}
+	if l.full {
+		l.overwritten += 1
+	}
+	l.keys[l.next] = key
+	l.next += 1
+	if l.next == len(l.keys) {
+		l.next = 0
+		l.full = true
+	}
+}
+
+// Copy of the log, oldest key first.
+func (l *missLog) snapshot() []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if !l.full {
+		return append([]string(nil), l.keys[:l.next]...)
+	}
+	r := make([]string, 0, len(l.keys))
+	r = append(r, l.keys[l.next:]...)
+	return append(r, l.keys[:l.next]...)
+}
+
+// Remember the last `size` keys missed by Get, GetNotStale and
+// GetNotStaleNow, stale items included, queryable with RecentMisses.
+// Meant as prefetch hints: a key missed often shows up many times.
+func WithMissTracking(size int) Option {
+	return func(b *LRUCache) {
+		if size > 0 {
+			b.missLog = &missLog{keys: make([]string, size)}
+		}
+	}
+}
+
+// Record a miss of Get or GetNotStale, with the lock held.
+func (b *LRUCache) missed(key string) {
+	if b.missLog != nil {
+		b.missLog.add(key)
+	}
+	if b.ghost != nil {
+		b.ghost.miss(key)
+	}
+	if b.sketch != nil {
+		b.sketch.add(key)
+	}
+	b.countLookup(false)
+}
+
+// Recently missed keys, oldest first, possibly repeated. Empty unless
+// the cache was created with WithMissTracking.
+func (b *LRUCache) RecentMisses() []string {
+	if b.missLog == nil {
+		return nil
+	}
+	return b.missLog.snapshot()
+}
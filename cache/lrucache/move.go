@@ -0,0 +1,107 @@
+package lrucache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Caches initialized so far, gives the order of lockPair.
+var initialized atomic.Uint64
+
+// Lock both caches, the one initialized first first, so that moves
+// between two caches in opposite directions can't deadlock. Waits for
+// both to be unfrozen, never holding one lock while waiting on the
+// other.
+func lockPair(x, y *LRUCache) {
+	if y.id < x.id {
+		x, y = y, x
+	}
+	for {
+		x.lock.Lock()
+		x.waitUnfrozen()
+		y.lock.Lock()
+		if !y.frozen {
+			return
+		}
+		x.lock.Unlock()
+		y.waitUnfrozen()
+		y.lock.Unlock()
+	}
+}
+
+// Move the items whose key satisfies `match` to `dst`, keeping their
+// values, expiry, expiry class and relative LRU order. Items pinned by
+// Acquire stay pinned in `dst`, their handles follow them on Release.
+// Classes `dst` doesn't have become its first class. Moved items are not recorded
+// as evictions. Both caches are locked for the whole move, so other
+// users see every item either in `b` or in `dst`, never in both or in
+// neither. Items `dst` has no room for stay in `b`. `match` is called
+// with both locks held and must not use the caches. Returns the number
+// of items moved. O(n)
+func (b *LRUCache) MoveTo(dst *LRUCache, match func(key string) bool) int {
+	if dst == b {
+		return 0
+	}
+	lockPair(b, dst)
+	defer b.lock.Unlock()
+	defer dst.lock.Unlock()
+
+	now := time.Now()
+	moved := 0
+	for el := b.lruList.Back(); el != nil; {
+		e := el.Value.(*entry)
+		el = el.Prev()
+		if match(e.key) && b.moveEntry(dst, e, e.key, now) {
+			moved += 1
+		}
+	}
+	return moved
+}
+
+// Move one item to `dst` like MoveTo, where it becomes the most
+// recently used item. Returns false if there is no such item or `dst`
+// has no room for it, then it stays in `b`. Caches are locked in the
+// order they were initialized, so concurrent moves in both directions
+// are safe, but the caller must not hold the lock of either, see
+// WithLock. O(log(n))
+func (b *LRUCache) MoveEntry(dst *LRUCache, key string) bool {
+	if dst == b {
+		return false
+	}
+	lockPair(b, dst)
+	defer b.lock.Unlock()
+	defer dst.lock.Unlock()
+
+	e := b.lookup(key)
+	if e == nil {
+		return false
+	}
+	return b.moveEntry(dst, e, key, time.Now())
+}
+
+// Set the entry in `dst` as `key` and remove it from `b`, with both
+// locks held. Nothing is removed until `dst` took the item.
+func (b *LRUCache) moveEntry(dst *LRUCache, e *entry, key string, now time.Time) bool {
+	value := e.value
+	if b.slotSize > 0 {
+		// The slot is reused once the entry is gone.
+		value = append([]byte(nil), value.([]byte)...)
+	}
+	class := b.classOf(e)
+	if class >= len(dst.queues) {
+		class = 0
+	}
+	if dst.setClass(key, value, e.expire, now, class) != nil {
+		return false
+	}
+	if e.pins > 0 {
+		d := dst.lookup(key)
+		dst.pin(d, e.pins)
+		if b.forwards == nil {
+			b.forwards = make(map[handleRef]*forward)
+		}
+		b.forwards[handleRef{e, e.gen}] = &forward{dst: dst, to: handleRef{d, d.gen}, pins: e.pins}
+	}
+	b.removeEntry(e)
+	return true
+}
@@ -0,0 +1,81 @@
+package lrucache
+
+import (
+	"time"
+)
+
+// Append a value to the list stored under the key, creating the list
+// if the key is missing. If the key holds something other than a list
+// it is replaced. The whole list is a single cache entry: it is
+// evicted as a whole and `expire` applies to all of it. Returns the
+// new length of the list, or 0 if there was no space for it.
+// O(log(n)) if expiry is set, O(1) when clear.
+func (b *LRUCache) Append(key string, value interface{}, expire time.Time) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.waitUnfrozen()
+
+	var list []interface{}
+	if e := b.lookup(key); e != nil {
+		list, _ = e.value.([]interface{})
+	}
+	list = append(list, value)
+	if b.set(key, list, expire, time.Time{}) != nil {
+		return 0
+	}
+	return len(list)
+}
+
+// Get a copy of the list stored under the key. Update its LRU
+// score. Returns false if the key is missing or doesn't hold a
+// list. O(k) for a list of k values.
+func (b *LRUCache) GetList(key string) (values []interface{}, ok bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	e := b.lookup(key)
+	if e == nil {
+		return nil, false
+	}
+	list, ok := e.value.([]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	b.touchEntry(e)
+	return append([]interface{}(nil), list...), true
+}
+
+// Keep only the `max` most recently appended values in the list
+// stored under the key, none if `max` is negative. Doesn't update the
+// LRU score. Returns the number of values dropped.
+func (b *LRUCache) TrimList(key string, max int) int {
+	if max < 0 {
+		max = 0
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.waitUnfrozen()
+
+	e := b.lookup(key)
+	if e == nil {
+		return 0
+	}
+	list, ok := e.value.([]interface{})
+	if !ok || len(list) <= max {
+		return 0
+	}
+
+	// Copy, so slices previously returned by Get stay intact.
+	dropped := len(list) - max
+	e.value = append([]interface{}(nil), list[dropped:]...)
+	if b.budget != nil {
+		size := b.itemSize(e.key, e.value)
+		b.budget.used.Add(int64(size - b.entrySizes[e.no]))
+		b.entrySizes[e.no] = size
+	}
+	if b.writeBehind != nil {
+		b.writeBehind.add(e.key, e.value)
+	}
+	return dropped
+}
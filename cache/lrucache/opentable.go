@@ -0,0 +1,86 @@
+package lrucache
+
+// Open addressing hash table of entries, an alternative to the
+// builtin map for the `table`, see WithOpenAddressing. It's sized on
+// creation for the capacity of the cache and never grows. Linear
+// probing, deletes shift the following entries back so there are no
+// tombstones.
+type openTable struct {
+	slots []openSlot
+	mask  uint64
+}
+
+type openSlot struct {
+	hash uint64 // of e.key, saves comparing keys on collisions
+	e    *entry // nil if the slot is free
+}
+
+func newOpenTable(capacity uint) *openTable {
+	size := openTableSize(capacity)
+	return &openTable{
+		slots: make([]openSlot, size),
+		mask:  size - 1,
+	}
+}
+
+func openTableSize(capacity uint) uint64 {
+	// Keep the load factor at or below 1/2 and at least one slot
+	// free, probing stops on a free slot.
+	size := uint64(1)
+	for size < 2*uint64(capacity) {
+		size <<= 1
+	}
+	return size
+}
+
+// FNV-1a. Doesn't allocate, unlike hashing a []byte(key).
+func hashString(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+// Slot holding the key, or the free slot where it would go.
+func (t *openTable) find(key string, hash uint64) uint64 {
+	i := hash & t.mask
+	for {
+		s := &t.slots[i]
+		if s.e == nil || (s.hash == hash && s.e.key == key) {
+			return i
+		}
+		i = (i + 1) & t.mask
+	}
+}
+
+func (t *openTable) get(key string) *entry {
+	return t.slots[t.find(key, hashString(key))].e
+}
+
+// Add or replace the entry for e.key.
+func (t *openTable) set(e *entry) {
+	hash := hashString(e.key)
+	t.slots[t.find(e.key, hash)] = openSlot{hash, e}
+}
+
+// Remove an entry added with set.
+func (t *openTable) del(e *entry) {
+	i := t.find(e.key, hashString(e.key))
+	if t.slots[i].e != e {
+		return
+	}
+
+	// Move back entries probed past the freed slot.
+	for j := (i + 1) & t.mask; t.slots[j].e != nil; j = (j + 1) & t.mask {
+		home := t.slots[j].hash & t.mask
+		// Can the entry at j move to i? Only if its home slot is
+		// not in the cyclic range (i, j].
+		if (j > i && (home <= i || home > j)) || (j < i && home <= i && home > j) {
+			t.slots[i] = t.slots[j]
+			i = j
+		}
+	}
+	t.slots[i] = openSlot{}
+}
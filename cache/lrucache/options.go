@@ -0,0 +1,89 @@
+package lrucache
+
+import (
+	"time"
+)
+
+// Option configures optional LRUCache behaviour. Options are passed
+// to NewLRUCache or Init and applied before any memory is reserved.
+type Option func(*LRUCache)
+
+// Round expiry times passed to Set to the nearest multiple of
+// `granularity`. Entries with similar TTLs end up sharing the same
+// expiry time, which reduces the heap reordering caused by tiny TTL
+// differences. An entry may expire up to granularity/2 earlier or
+// later than requested.
+func WithExpiryGranularity(granularity time.Duration) Option {
+	return func(b *LRUCache) {
+		b.expiryGranularity = granularity
+	}
+}
+
+// Only move an item to the front of the LRU list once it was accessed
+// at least `n` times, counting the Set that created it. New items go
+// to the front as usual, but a scan hitting items read only once or
+// twice before doesn't move them ahead of the hot set. A lightweight
+// form of scan resistance, n <= 2 is plain LRU.
+func WithPromoteAfter(n int) Option {
+	return func(b *LRUCache) {
+		b.promoteAfter = n
+	}
+}
+
+// Treat items as expired only once they are `tolerance` past their
+// expiry time. Useful when items are set by writers whose clocks
+// disagree slightly with the `now` used for expiring them.
+func WithClockSkewTolerance(tolerance time.Duration) Option {
+	return func(b *LRUCache) {
+		b.skewTolerance = tolerance
+	}
+}
+
+// Experimental. Back the cache with an open addressing hash table
+// specialised for string keys instead of the builtin map. The table
+// is allocated on creation, sized for the capacity, and never grows,
+// in line with the rest of the cache. See BenchmarkGet* and
+// BenchmarkSet* for the comparison. Only the map is replaced: keys are
+// still hashed once more by MultiLRUCache to pick the bucket, and by
+// WithAdmissionFilter, the table doesn't share their hashes.
+func WithOpenAddressing() Option {
+	return func(b *LRUCache) {
+		b.openAddressing = true
+	}
+}
+
+// When a Set of a new key would fill the cache above `loadFactor` of
+// its capacity, for example 0.9, first evict up to `maxReapPerSet`
+// expired items from the head of the expiry queue. Keeps expired items
+// from taking up memory without a janitor goroutine, while bounding
+// the extra work done by a single Set to O(maxReapPerSet*log(n)).
+func WithProactiveExpiry(loadFactor float64, maxReapPerSet int) Option {
+	return func(b *LRUCache) {
+		b.reapLoadFactor = loadFactor
+		b.reapPerSet = maxReapPerSet
+	}
+}
+
+// Consult `canEvict` before pushing an item out to make room for a
+// new one. If the least used item is vetoed, the LRU list is walked
+// towards the most used one until an item that may be evicted is
+// found. If every item is vetoed Set fails with ErrCacheFull. Worst
+// case Set calls `canEvict` for each item in the cache, O(n), see
+// WithMaxEvictionScan. The function is called with the lock held and
+// must not use the cache.
+func WithCanEvict(canEvict func(key string, value interface{}) bool) Option {
+	return func(b *LRUCache) {
+		b.canEvict = canEvict
+	}
+}
+
+// Look at no more than `n` items from the least used end when looking
+// for one to evict, failing the Set with ErrCacheFull if all of them
+// are vetoed or pinned. Bounds Set to O(n) even when most items can't
+// be evicted. Expired items are evicted irrespective of the limit.
+// Stats.EvictionScans tells how many items were looked at.
+func WithMaxEvictionScan(n int) Option {
+	return func(b *LRUCache) {
+		b.maxEvictionScan = n
+	}
+}
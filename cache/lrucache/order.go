@@ -0,0 +1,39 @@
+package lrucache
+
+import (
+	"sort"
+	"time"
+)
+
+// Keys in the order they would be evicted to make room, the next
+// victim first: expired items by expiry time, then the rest from the
+// least recently used. Pinned items are left out. Doesn't ask CanEvict
+// and ignores WithSizeAwareEviction. Mostly for tests asserting on the
+// eviction policy. O(n*log(n))
+func (b *LRUCache) EvictionOrder() []string {
+	now := time.Now()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	var expired PriorityQueue
+	for _, q := range b.queues {
+		for _, e := range q {
+			if b.isExpired(e, now) {
+				expired = append(expired, e)
+			}
+		}
+	}
+	sort.Slice(expired, expired.Less)
+
+	keys := make([]string, 0, b.lruList.Len())
+	for _, e := range expired {
+		keys = append(keys, e.key)
+	}
+	for el := b.lruList.Back(); el != nil; el = el.Prev() {
+		e := el.Value.(*entry)
+		if e.pins == 0 && !b.isExpired(e, now) {
+			keys = append(keys, e.key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,81 @@
+package lrucache
+
+import (
+	"sort"
+	"time"
+)
+
+// Snapshot of the priority queues holding items with expiry set, all
+// the expiry classes together.
+type PriorityQueueStats struct {
+	Len        int       // number of items with expiry set
+	MinExpire  time.Time // earliest expiry, zero if Len is 0
+	MaxExpire  time.Time // latest expiry, zero if Len is 0
+	HeadExpire time.Time // expiry of the next item to be evicted by Expire
+}
+
+// Describe the priority queues, to see how expiry work is spread in
+// time. Only the leaves of the heaps are scanned for the latest
+// expiry. O(n)
+func (b *LRUCache) PriorityQueueStats() PriorityQueueStats {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	var s PriorityQueueStats
+	for _, q := range b.queues {
+		if len(q) == 0 {
+			continue
+		}
+		if s.Len == 0 || q[0].expire.Before(s.HeadExpire) {
+			s.HeadExpire = q[0].expire
+		}
+		s.Len += len(q)
+		for _, e := range q[len(q)/2:] {
+			if e.expire.After(s.MaxExpire) {
+				s.MaxExpire = e.expire
+			}
+		}
+	}
+	s.MinExpire = s.HeadExpire
+	return s
+}
+
+// Expiry time of the item that expires first, false if no item has
+// expiry set. O(1) per expiry class
+func (b *LRUCache) NextExpiry() (time.Time, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	var next time.Time
+	ok := false
+	for _, q := range b.queues {
+		if len(q) > 0 && (!ok || q[0].expire.Before(next)) {
+			next, ok = q[0].expire, true
+		}
+	}
+	return next, ok
+}
+
+// Count the items by remaining TTL. `buckets` are increasing upper
+// bounds: counts[0] is the number of items expiring within
+// buckets[0] of `now`, including the ones already expired, counts[i]
+// of the ones expiring in [buckets[i-1], buckets[i]). The last two
+// counts are the items expiring later than the last bound and the
+// ones without expiry, so len(buckets)+2 counts are returned. O(n)
+func (b *LRUCache) TTLHistogram(now time.Time, buckets []time.Duration) []int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	counts := make([]int, len(buckets)+2)
+	expiring := 0
+	for _, q := range b.queues {
+		for _, e := range q {
+			ttl := e.expire.Sub(now)
+			i := sort.Search(len(buckets), func(i int) bool { return ttl < buckets[i] })
+			counts[i] += 1
+		}
+		expiring += len(q)
+	}
+	counts[len(buckets)+1] = b.lruList.Len() - expiring
+	return counts
+}
@@ -6,8 +6,20 @@ func (pq PriorityQueue) Len() int {
 	return len(pq)
 }
 
+// Items expiring at the same time are ordered by insertion, the one
+// set first comes first, so expiry is deterministic even with coarse
+// TTLs or WithExpiryGranularity. Overwriting an item with Set counts
+// as inserting it again.
 func (pq PriorityQueue) Less(i, j int) bool {
-	return pq[i].expire.Before(pq[j].expire)
+	return earlier(pq[i], pq[j])
+}
+
+// Whether `a` expires before `b`, in the order of the expiry queues.
+func earlier(a, b *entry) bool {
+	if a.expire.Equal(b.expire) {
+		return a.seq < b.seq
+	}
+	return a.expire.Before(b.expire)
 }
 
 func (pq PriorityQueue) Swap(i, j int) {
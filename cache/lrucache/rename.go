@@ -0,0 +1,37 @@
+package lrucache
+
+// Move the item at `oldKey` to `newKey` keeping its value, expiry,
+// popularity and LRU position. An item already at `newKey` is dropped
+// as if overwritten by Set, it's not recorded as evicted. Returns
+// false if there is no `oldKey`. O(1)
+func (b *LRUCache) Rename(oldKey, newKey string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.waitUnfrozen()
+
+	return b.rename(oldKey, newKey)
+}
+
+func (b *LRUCache) rename(oldKey, newKey string) bool {
+	e := b.lookup(oldKey)
+	if e == nil {
+		return false
+	}
+	if oldKey == newKey {
+		return true
+	}
+	if other := b.lookup(newKey); other != nil {
+		b.removeEntry(other)
+	}
+
+	if b.openTable != nil {
+		b.openTable.del(e)
+		e.key = newKey
+		b.openTable.set(e)
+	} else {
+		delete(b.table, oldKey)
+		e.key = newKey
+		b.table[newKey] = e
+	}
+	return true
+}
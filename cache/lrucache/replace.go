@@ -0,0 +1,30 @@
+package lrucache
+
+import (
+	"time"
+)
+
+// Value with its expiry time, as accepted by ReplaceAll.
+type ValueExpire struct {
+	Value  interface{}
+	Expire time.Time
+}
+
+// Atomically replace the whole content of the cache. Existing entries
+// are removed and `entries` are inserted under a single lock, readers
+// see either the old or the new content, never a mix. No memory is
+// allocated, the entries are reused. If there are more entries than
+// capacity an arbitrary subset is kept. Returns the number of entries
+// in the cache afterwards. O(n*log(n))
+func (b *LRUCache) ReplaceAll(entries map[string]ValueExpire) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.waitUnfrozen()
+
+	b.clear()
+	now := time.Now()
+	for key, ve := range entries {
+		b.set(key, ve.Value, ve.Expire, now)
+	}
+	return b.lruList.Len()
+}
@@ -0,0 +1,101 @@
+package lrucache
+
+import (
+	"encoding/gob"
+	"io"
+	"time"
+)
+
+// Item as written by Save. Values are encoded with encoding/gob, so
+// types other than the basic ones must be registered with
+// gob.Register. The expiry is stored as the TTL remaining at the time
+// of Save, so a snapshot can be loaded on a machine with a different
+// clock. Items without expiry store the NoExpiry sentinel. Older
+// snapshots stored the absolute Expire instead, zero for no expiry,
+// Load still reads them.
+type savedEntry struct {
+	Key    string
+	Value  interface{}
+	TTL    time.Duration
+	Expire time.Time // legacy, never written
+}
+
+func ttlOf(expire, now time.Time) time.Duration {
+	if expire.IsZero() {
+		return NoExpiry
+	}
+	return expire.Sub(now)
+}
+
+// Write the items to `w`, least recently used first, for Load to read
+// back. Items that already expired are skipped. The items are copied
+// under the lock and encoded after it is released. O(n)
+func (b *LRUCache) Save(w io.Writer) error {
+	_, _, err := b.SaveCompact(w, 0)
+	return err
+}
+
+// Like Save, but skip items that expire within `minRemaining` from
+// now, they wouldn't be of much use after a restart. Items without
+// expiry are always written. Returns the number of items written and
+// skipped.
+func (b *LRUCache) SaveCompact(w io.Writer, minRemaining time.Duration) (written, skipped int, err error) {
+	now := time.Now()
+	deadline := now.Add(minRemaining)
+
+	b.lock.Lock()
+	items := make([]savedEntry, 0, b.lruList.Len())
+	for el := b.lruList.Back(); el != nil; el = el.Prev() {
+		e := el.Value.(*entry)
+		if !e.expire.IsZero() && !e.expire.After(deadline) {
+			skipped += 1
+			continue
+		}
+		items = append(items, savedEntry{Key: e.key, Value: e.value, TTL: ttlOf(e.expire, now)})
+	}
+	b.lock.Unlock()
+
+	enc := gob.NewEncoder(w)
+	for i := range items {
+		items[i].Value = resolve(items[i].Value)
+		if err = enc.Encode(&items[i]); err != nil {
+			return written, skipped, err
+		}
+		written += 1
+	}
+	return written, skipped, nil
+}
+
+// Read items written by Save and Set them, restoring their LRU order.
+// Expiry is the time of Load plus the TTL remaining at Save, the time
+// between the two is not accounted for. Items saved without expiry get
+// no expiry. Returns the number of items read.
+func (b *LRUCache) Load(r io.Reader) (int, error) {
+	now := time.Now()
+	dec := gob.NewDecoder(r)
+	n := 0
+	for {
+		var item savedEntry
+		if err := dec.Decode(&item); err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+		n += 1
+		var expire time.Time
+		if item.TTL == 0 {
+			// Written before the TTL, gob leaves out zero values and
+			// Save never writes a zero TTL.
+			if !item.Expire.IsZero() && !item.Expire.After(now) {
+				continue
+			}
+			expire = item.Expire
+		} else if item.TTL != NoExpiry {
+			if item.TTL <= 0 {
+				continue
+			}
+			expire = now.Add(item.TTL)
+		}
+		b.insert(item.Key, item.Value, expire, time.Time{})
+	}
+}
@@ -0,0 +1,71 @@
+package lrucache
+
+import (
+	"fmt"
+)
+
+// Every `every` insertions and removals run a few cheap consistency
+// checks of the bookkeeping and pass what's wrong to `report` instead
+// of panicking. Meant as an early warning of corruption in production:
+// the checks are O(1) and far from complete. `report` is called with
+// the lock held and must not use the cache.
+func WithSelfCheck(every int, report func(error)) Option {
+	return func(b *LRUCache) {
+		b.selfCheckEvery = every
+		b.selfCheckReport = report
+	}
+}
+
+// Count an insertion or removal, run the checks if it's time.
+func (b *LRUCache) selfCheckTick() {
+	if b.selfCheckEvery <= 0 {
+		return
+	}
+	b.selfCheckOps += 1
+	if b.selfCheckOps < b.selfCheckEvery {
+		return
+	}
+	b.selfCheckOps = 0
+	if err := b.selfCheck(); err != nil {
+		b.protect("SelfCheck", func() { b.selfCheckReport(err) })
+	}
+}
+
+func (b *LRUCache) selfCheck() error {
+	n, queued := b.lruList.Len(), 0
+	if b.table != nil && len(b.table) != n {
+		return fmt.Errorf("lrucache: %d items in the table, %d in the list", len(b.table), n)
+	}
+	for _, pq := range b.queues {
+		queued += len(pq)
+		if l := len(pq); l > 0 {
+			for _, i := range []int{0, l - 1} {
+				if pq[i].index != i {
+					return fmt.Errorf("lrucache: item at %d in the expiry queue has index %d", i, pq[i].index)
+				}
+			}
+		}
+	}
+	if queued > n {
+		return fmt.Errorf("lrucache: %d items in the expiry queues, %d in the list", queued, n)
+	}
+	for _, el := range []*Element{b.lruList.Front(), b.lruList.Back()} {
+		if el == nil {
+			continue
+		}
+		e := el.Value.(*entry)
+		if b.lookup(e.key) != e {
+			return fmt.Errorf("lrucache: item %q in the list is not in the table", e.key)
+		}
+		if b.classOf(e) >= len(b.queues) {
+			return fmt.Errorf("lrucache: item %q has expiry class %d out of range", e.key, b.classOf(e))
+		}
+		if pq := *b.queue(e); e.index >= len(pq) || (e.index >= 0 && pq[e.index] != e) {
+			return fmt.Errorf("lrucache: item %q has index %d outside of the expiry queue", e.key, e.index)
+		}
+	}
+	if s := b.slru; s != nil && s.boundary != nil && !s.hot[s.boundary.Value.(*entry).no] {
+		return fmt.Errorf("lrucache: last protected item %q is on probation", s.boundary.Value.(*entry).key)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package lrucache
+
+import (
+	"unsafe"
+)
+
+// Size in bytes of a single cache entry, excluding the key and the
+// value it points to, and the state kept aside for the options.
+func EntrySize() uintptr {
+	return unsafe.Sizeof(entry{})
+}
+
+// Memory reserved up front by a cache of the given capacity created
+// with `options`: the block of entries, the priority queue backing
+// arrays and whatever the options allocate on creation, like value
+// slots, the admission sketch or the diagnostic logs. The `table` map
+// is sized on creation too, but its footprint depends on the Go
+// runtime and is not included. Keys and values are not included
+// either.
+func ReservedBytes(capacity uint, options ...Option) uint64 {
+	b := &LRUCache{}
+	for _, option := range options {
+		option(b)
+	}
+	b.boundDiagnostics()
+
+	n := uint64(capacity)
+	pointer := uint64(unsafe.Sizeof((*entry)(nil)))
+	total := n * (uint64(EntrySize()) + pointer*uint64(max(len(b.queues), 1)))
+	if b.openAddressing {
+		total += openTableSize(capacity) * uint64(unsafe.Sizeof(openSlot{}))
+	}
+	if b.slotSize > 0 {
+		total += n * (uint64(b.slotSize) + uint64(unsafe.Sizeof(interface{}(nil))))
+	}
+	if b.budget != nil {
+		total += n * uint64(unsafe.Sizeof(int(0)))
+	}
+	if len(b.queues) > 1 {
+		total += n
+	}
+	if b.demote != nil {
+		total += n
+	}
+	if b.slruFraction > 0 {
+		total += n
+	}
+	if b.sketch != nil {
+		total += uint64(len(b.sketch.rows)) * sketchWidth(capacity)
+	}
+	str := uint64(unsafe.Sizeof(""))
+	if b.missLog != nil {
+		total += uint64(len(b.missLog.keys)) * str
+	}
+	if b.ghost != nil {
+		total += uint64(len(b.ghost.keys)) * str
+	}
+	if b.evictionLog != nil {
+		total += uint64(len(b.evictionLog.records)) * uint64(unsafe.Sizeof(EvictionRecord{}))
+	}
+	return total
+}
@@ -0,0 +1,28 @@
+package lrucache
+
+// When making room for a new item, look at the `window` least
+// recently used items that may be evicted and evict the one with the
+// highest score: its size, as given by `size`, weighted by how cold it
+// is. The coldest item has weight `window`, the next one `window`-1
+// and so on. Large cold items go first, so fewer evictions reclaim the
+// same memory, at the cost of evicting items a little more recent
+// than plain LRU would. Lazy values that were never read are passed
+// to `size` as nil. Set does O(window) more work when the cache is
+// full. `size` is called with the lock held and must not use the
+// cache.
+func WithSizeAwareEviction(size func(key string, value interface{}) int, window int) Option {
+	return func(b *LRUCache) {
+		if window > 0 {
+			b.sizeOf = size
+			b.sizeWindow = window
+		}
+	}
+}
+
+// Score of the candidate for eviction, `coldness` items more recent
+// than the coldest one.
+func (b *LRUCache) evictionScore(e *entry, coldness int) int {
+	size := 0
+	b.protect("SizeAwareEviction", func() { size = b.sizeOf(e.key, peek(e.value)) })
+	return size * (b.sizeWindow - coldness)
+}
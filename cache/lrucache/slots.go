@@ -0,0 +1,48 @@
+package lrucache
+
+import (
+	"errors"
+)
+
+// Returned by Set when WithValueSlots is used and the value isn't a
+// []byte no longer than the slot.
+var ErrValueTooLarge = errors.New("lrucache: value doesn't fit in the slot")
+
+// Store values in fixed size slots reserved on creation, so that
+// memory use is bounded for values too, not only for entries. Every
+// entry gets `size` bytes, capacity*size in one block, and Set copies
+// the value into the slot of its entry. Values must be []byte of at
+// most `size` bytes, anything else fails with ErrValueTooLarge and is
+// not stored, as are values given to EntryHandle.SetValue. A steady
+// state Set doesn't allocate as long as the values reusing an entry
+// have the same length, otherwise only a slice header is allocated.
+//
+// Getters return the slot itself: the slice is valid only until the
+// item is overwritten or removed, copy it to keep it.
+func WithValueSlots(size int) Option {
+	return func(b *LRUCache) {
+		b.slotSize = size
+	}
+}
+
+// The value as []byte if it fits in a slot.
+func (b *LRUCache) slotValue(value interface{}) ([]byte, error) {
+	v, ok := value.([]byte)
+	if !ok || len(v) > b.slotSize {
+		return nil, ErrValueTooLarge
+	}
+	return v, nil
+}
+
+// Copy the value into the slot of the entry, returning the copy.
+func (b *LRUCache) fillSlot(e *entry, v []byte) interface{} {
+	start := int(e.no) * b.slotSize
+	slot := b.slotData[start : start+len(v) : start+len(v)]
+	copy(slot, v)
+	if cur, ok := b.slotBoxes[e.no].([]byte); !ok || len(cur) != len(v) {
+		// Boxing the header allocates, reuse it while the length
+		// stays the same.
+		b.slotBoxes[e.no] = slot
+	}
+	return b.slotBoxes[e.no]
+}
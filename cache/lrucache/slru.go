@@ -0,0 +1,86 @@
+package lrucache
+
+// Segmented LRU state, see WithSLRU. The protected segment is the
+// front of lruList up to and including `boundary`, the probationary
+// segment is the rest.
+type slru struct {
+	maxProtected int      // protected segment size limit
+	protected    int      // items in the protected segment
+	boundary     *Element // last protected element, nil if none
+	hot          []bool   // by entry number, in the protected segment
+}
+
+// Split the LRU list in two segments. New items enter the
+// probationary segment, and move to the protected one when hit
+// again. The protected segment holds at most `protectedFraction` of
+// the capacity, its least recently used items fall back to the head
+// of the probationary segment. Eviction takes probationary items
+// first, so a scan of one-off keys can't push out the items that
+// were used more than once. Overwriting an item accessed before puts
+// it back in the protected segment. Replaces WithPromoteAfter.
+func WithSLRU(protectedFraction float64) Option {
+	return func(b *LRUCache) {
+		b.slruFraction = protectedFraction
+	}
+}
+
+// Put a new entry in its segment. O(1)
+func (s *slru) insert(b *LRUCache, e *entry) {
+	if e.hits > 1 {
+		s.protect(b, e)
+		return
+	}
+	s.pushProbation(b, e)
+}
+
+// Move a hit entry to the front of the protected segment. O(1)
+func (s *slru) touch(b *LRUCache, e *entry) {
+	s.unlink(b, e)
+	s.protect(b, e)
+}
+
+// Move an entry to the head of the probationary segment. O(1)
+func (s *slru) requeue(b *LRUCache, e *entry) {
+	s.unlink(b, e)
+	s.pushProbation(b, e)
+}
+
+// Take an entry out of the LRU list, keeping the segments in order.
+func (s *slru) unlink(b *LRUCache, e *entry) {
+	if s.hot[e.no] {
+		if s.boundary == &e.element {
+			s.boundary = nil
+			if s.protected > 1 {
+				s.boundary = e.element.Prev()
+			}
+		}
+		s.protected -= 1
+		s.hot[e.no] = false
+	}
+	b.lruList.Remove(&e.element)
+}
+
+func (s *slru) protect(b *LRUCache, e *entry) {
+	b.lruList.PushElementFront(&e.element)
+	s.hot[e.no] = true
+	s.protected += 1
+	if s.boundary == nil {
+		s.boundary = &e.element
+	}
+	if s.protected > s.maxProtected {
+		// The least recently used protected item is on probation
+		// again, it's already in place.
+		last := s.boundary.Value.(*entry)
+		s.boundary = s.boundary.Prev()
+		s.hot[last.no] = false
+		s.protected -= 1
+	}
+}
+
+func (s *slru) pushProbation(b *LRUCache, e *entry) {
+	if s.boundary != nil {
+		b.lruList.InsertElementAfter(&e.element, s.boundary)
+	} else {
+		b.lruList.PushElementFront(&e.element)
+	}
+}
@@ -0,0 +1,34 @@
+package lrucache
+
+import (
+	"time"
+)
+
+// Spread the expiry of items set in bulk with the same TTL over
+// `window`, so that they don't all expire at once and Expire doesn't
+// hold the lock for a long burst. A Set whose expiry is within
+// `window` after the first one of the batch joins the batch: its
+// expiry is moved to the start of the batch plus an offset, if that's
+// not earlier than the requested expiry. The offsets are deterministic
+// and evenly spread, the n-th item of a batch gets the fractional part
+// of n times the golden ratio of the window. A Set expiring later than
+// that starts a new batch. Items never expire early, and at most
+// `window` late. Applied after WithExpiryGranularity.
+func WithExpirySpread(window time.Duration) Option {
+	return func(b *LRUCache) {
+		b.spreadWindow = window
+	}
+}
+
+const goldenRatio = 0.6180339887498949
+
+// Expiry for the next item of the current batch.
+func (b *LRUCache) spreadExpire(expire time.Time) time.Time {
+	if b.spreadN == 0 || expire.Before(b.spreadBase) || !expire.Before(b.spreadBase.Add(b.spreadWindow)) {
+		b.spreadBase, b.spreadN = expire, 0
+	}
+	n := float64(b.spreadN) * goldenRatio
+	b.spreadN += 1
+	offset := time.Duration((n - float64(int64(n))) * float64(b.spreadWindow))
+	return b.spreadBase.Add(max(offset, expire.Sub(b.spreadBase)))
+}
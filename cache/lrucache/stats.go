@@ -0,0 +1,86 @@
+package lrucache
+
+import (
+	"time"
+)
+
+// Counters kept over the lifetime of the cache.
+type Stats struct {
+	// Keys found by Get, GetNotStale and GetOrdered, stale ones being
+	// a miss for GetNotStale, and keys not found. Estimates if
+	// Sampled.
+	Hits, Misses uint64
+	// Hits and Misses are scaled up from a sample, see
+	// WithStatsSampling.
+	Sampled bool
+	// Items removed, by reason. Tells whether the cache churns on
+	// capacity or turns over on expiry. Overwrites are not counted.
+	Removals map[EvictReason]uint64
+	// Items looked at while walking the LRU list for one that may be
+	// evicted. Grows faster than capacity evictions when items are
+	// vetoed or pinned, see WithMaxEvictionScan.
+	EvictionScans uint64
+	// Items pushed out to make room that were demoted instead of
+	// evicted, see WithDemotion.
+	Demotions uint64
+	// New items turned down by WithAdmissionFilter.
+	Rejections uint64
+}
+
+// Snapshot of the counters. O(1)
+func (b *LRUCache) Stats() Stats {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	s := Stats{
+		Hits:          b.hits * b.sampleRate,
+		Misses:        b.misses * b.sampleRate,
+		Sampled:       b.sampleRate > 1,
+		Removals:      make(map[EvictReason]uint64, evictReasons),
+		EvictionScans: b.evictionScans,
+		Demotions:     b.demotions,
+		Rejections:    b.rejections,
+	}
+	for r, n := range b.removals {
+		s.Removals[EvictReason(r)] = n
+	}
+	return s
+}
+
+// Count only about one in `rate` Get and GetNotStale calls in the hit
+// and miss counters of Stats and WithHitRateWindow, picked at random,
+// and scale the counts back up. The counts become estimates, with a
+// relative error of roughly 1/sqrt(count/rate), in exchange the
+// calls not sampled skip the counters and don't read the clock, which
+// shows on caches doing millions of lookups a second. The ghost list
+// and the miss log still see every miss.
+func WithStatsSampling(rate int) Option {
+	return func(b *LRUCache) {
+		if rate > 1 {
+			b.sampleRate = uint64(rate)
+		}
+	}
+}
+
+// Count a hit or a miss, if sampled. Called with the lock held.
+func (b *LRUCache) countLookup(hit bool) {
+	if b.sampleRate > 1 {
+		// xorshift64
+		x := b.sampleState
+		x ^= x << 13
+		x ^= x >> 7
+		x ^= x << 17
+		b.sampleState = x
+		if x%b.sampleRate != 0 {
+			return
+		}
+	}
+	if hit {
+		b.hits += 1
+	} else {
+		b.misses += 1
+	}
+	if b.hitWindow != nil {
+		b.hitWindow.record(hit, time.Now())
+	}
+}
@@ -0,0 +1,97 @@
+package lrucache
+
+import (
+	"time"
+)
+
+// Operations on a cache with its lock already held, see WithLock. A Tx
+// is only valid inside the function passed to WithLock.
+type Tx struct {
+	b *LRUCache
+}
+
+func (tx *Tx) cache() *LRUCache {
+	if tx.b == nil {
+		panic("lrucache: Tx used outside of WithLock")
+	}
+	return tx.b
+}
+
+// Like LRUCache.Get. Lazy values are computed with the lock held.
+func (tx *Tx) Get(key string) (value interface{}, ok bool) {
+	b := tx.cache()
+	e := b.lookup(key)
+	if e == nil {
+		return nil, false
+	}
+	b.touchEntry(e)
+	e.value = resolve(e.value)
+	return e.value, true
+}
+
+// Like LRUCache.Set.
+func (tx *Tx) Set(key string, value interface{}, expire time.Time) error {
+	return tx.cache().set(key, value, expire, time.Time{})
+}
+
+// Like LRUCache.Del. Lazy values are computed with the lock held.
+func (tx *Tx) Del(key string) (value interface{}, ok bool) {
+	b := tx.cache()
+	e := b.lookup(key)
+	if e == nil {
+		return nil, false
+	}
+	value = resolve(e.value)
+	b.evictEntry(e, EvictDeleted, time.Time{})
+	return value, true
+}
+
+// Run `fn` with the cache locked, so that a sequence of operations on
+// many keys, like moving a value from one key to another, is atomic.
+// Inside `fn` only use the cache through `tx`: calling methods of the
+// cache directly would deadlock.
+func (b *LRUCache) WithLock(fn func(tx *Tx)) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.waitUnfrozen()
+
+	tx := &Tx{b}
+	defer func() { tx.b = nil }()
+	fn(tx)
+}
+
+// Like LRUCache.Rename.
+func (tx *Tx) Rename(oldKey, newKey string) bool {
+	return tx.cache().rename(oldKey, newKey)
+}
+
+// Move the item at `oldKey` to the cache of `dst` as `newKey`, like
+// MoveEntry, with both caches locked by WithLock. Returns false if
+// there is no `oldKey` or the other cache didn't take the item, then
+// it stays at `oldKey`. Lazy values are computed with the lock held.
+func (tx *Tx) MoveTo(dst *Tx, oldKey, newKey string) bool {
+	b, d := tx.cache(), dst.cache()
+	e := b.lookup(oldKey)
+	if e == nil {
+		return false
+	}
+	if b == d {
+		return b.rename(oldKey, newKey)
+	}
+	e.value = resolve(e.value)
+	return b.moveEntry(d, e, newKey, time.Time{})
+}
+
+// Remove a key returning its value and expiry, for example to set it
+// in another cache. Unlike Del it's not recorded as evicted. Lazy
+// values are computed with the lock held.
+func (tx *Tx) Take(key string) (value interface{}, expire time.Time, ok bool) {
+	b := tx.cache()
+	e := b.lookup(key)
+	if e == nil {
+		return nil, time.Time{}, false
+	}
+	value, expire = resolve(e.value), e.expire
+	b.removeEntry(e)
+	return value, expire, true
+}
@@ -0,0 +1,123 @@
+package lrucache
+
+import (
+	"container/heap"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Seed the cache from a stream written by ExportNDJSON, for example a
+// peer's, in one pass: the lists are filled directly and the expiry
+// heap is built once at the end instead of item by item. Only free
+// slots are used, nothing is evicted and keys already in the cache
+// keep their value. Expired items are skipped, and so are items past
+// the capacity, the stream being most recently used first that keeps
+// the most recent ones. Warmed items are less recently used than the
+// ones already in the cache and are not written behind or through.
+// Values are as decoded by encoding/json, numbers become float64.
+// Returns the number of items loaded and skipped. Snapshots written by
+// Save are read with Load instead. O(n)
+func (b *LRUCache) WarmFrom(r io.Reader) (loaded, skipped int, err error) {
+	if b.draining.Load() {
+		return 0, 0, ErrDraining
+	}
+	// Decode without the lock, keeping only what may fit.
+	now := time.Now()
+	b.lock.RLock()
+	room := b.freeList.Len()
+	b.lock.RUnlock()
+	var items []exportedEntry
+	dec := json.NewDecoder(r)
+	for {
+		var item exportedEntry
+		if err = dec.Decode(&item); err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, 0, err
+		}
+		if len(items) == room || (item.Expire != nil && b.isExpired(&entry{expire: *item.Expire}, now)) {
+			skipped += 1
+			continue
+		}
+		items = append(items, item)
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err := b.waitUnfrozenOrFail(); err != nil {
+		return 0, skipped, err
+	}
+	if b.draining.Load() {
+		// Drained while decoding.
+		return 0, skipped, ErrDraining
+	}
+
+	for _, item := range items {
+		if b.freeList.Len() == 0 || b.lookup(item.Key) != nil {
+			skipped += 1
+			continue
+		}
+		// Check the item before taking a free entry.
+		value := item.Value
+		if b.slotSize > 0 {
+			v, err := b.slotValue(value)
+			if err != nil {
+				skipped += 1
+				continue
+			}
+			value = v
+		}
+		size := 0
+		if b.budget != nil {
+			size = b.itemSize(item.Key, value)
+			if b.budget.used.Load()+int64(size) > b.budget.limit {
+				skipped += 1
+				continue
+			}
+		}
+
+		e := b.freeList.Front().Value.(*entry)
+		e.key = item.Key
+		e.value = value
+		if b.slotSize > 0 {
+			e.value = b.fillSlot(e, value.([]byte))
+		}
+		e.expire = time.Time{}
+		if item.Expire != nil {
+			e.expire = *item.Expire
+		}
+		e.hits = 1
+		b.setClassOf(e, 0)
+		if b.entryDemotions != nil {
+			b.entryDemotions[e.no] = 0
+		}
+		if b.budget != nil {
+			b.entrySizes[e.no] = size
+			b.budget.used.Add(int64(size))
+		}
+
+		b.seq += 1
+		e.seq = b.seq
+		if !e.expire.IsZero() {
+			e.index = len(b.queues[0])
+			b.queues[0] = append(b.queues[0], e)
+		}
+		b.freeList.Remove(&e.element)
+		b.lruList.PushElementBack(&e.element)
+		if b.openTable != nil {
+			b.openTable.set(e)
+		} else {
+			b.table[e.key] = e
+		}
+		loaded += 1
+	}
+	heap.Init(&b.queues[0])
+	if n := b.lruList.Len(); n > b.peakLen {
+		b.peakLen = n
+	}
+	if b.fullness != nil {
+		b.fullness.update(b)
+	}
+	return loaded, skipped, nil
+}
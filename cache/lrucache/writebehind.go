@@ -0,0 +1,178 @@
+package lrucache
+
+import (
+	"sync"
+	"time"
+)
+
+// Items set but not flushed to the backing store yet, and the
+// background goroutine flushing them, see WithWriteBehind.
+type writeBehind struct {
+	interval  time.Duration
+	batchSize int
+	flush     func([]KeyValue) error
+
+	lock    sync.Mutex
+	pending map[string]interface{} // latest value of every dirty key
+	order   []string               // dirty keys, oldest first
+
+	kick chan struct{} // a batch is full
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// Longest wait between retries is interval << maxBackoff.
+const maxBackoff = 6
+
+func (w *writeBehind) add(key string, value interface{}) {
+	w.lock.Lock()
+	if _, ok := w.pending[key]; !ok {
+		w.order = append(w.order, key)
+	}
+	w.pending[key] = value
+	full := len(w.order) >= w.batchSize
+	w.lock.Unlock()
+
+	if full {
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Take up to batchSize of the oldest pending items.
+func (w *writeBehind) take() []KeyValue {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	n := len(w.order)
+	if n > w.batchSize {
+		n = w.batchSize
+	}
+	batch := make([]KeyValue, n)
+	for i, key := range w.order[:n] {
+		batch[i] = KeyValue{key, w.pending[key]}
+		delete(w.pending, key)
+	}
+	w.order = w.order[n:]
+	return batch
+}
+
+// Put a batch that failed to flush back in front, except for the keys
+// set again in the meantime.
+func (w *writeBehind) putBack(batch []KeyValue) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	var keys []string
+	for _, kv := range batch {
+		if _, ok := w.pending[kv.Key]; !ok {
+			w.pending[kv.Key] = kv.Value
+			keys = append(keys, kv.Key)
+		}
+	}
+	w.order = append(keys, w.order...)
+}
+
+// Flush batches until nothing is pending or a flush fails.
+func (w *writeBehind) flushAll(b *LRUCache) error {
+	for {
+		batch := w.take()
+		if len(batch) == 0 {
+			return nil
+		}
+		for i := range batch {
+			batch[i].Value = resolve(batch[i].Value)
+		}
+		var err error
+		if perr := b.protect("WriteBehind", func() { err = w.flush(batch) }); perr != nil {
+			err = perr
+		}
+		if err != nil {
+			w.putBack(batch)
+			return err
+		}
+	}
+}
+
+func (w *writeBehind) run(b *LRUCache) {
+	defer close(w.done)
+
+	failures := 0
+	timer := time.NewTimer(w.interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-w.kick:
+			if failures > 0 {
+				// Backing off, the timer retries.
+				continue
+			}
+			timer.Stop()
+			select {
+			case <-timer.C:
+			default:
+			}
+		case <-w.stop:
+			w.flushAll(b)
+			return
+		}
+
+		if w.flushAll(b) != nil {
+			if failures < maxBackoff {
+				failures += 1
+			}
+		} else {
+			failures = 0
+		}
+		timer.Reset(w.interval << failures)
+	}
+}
+
+// Stop the goroutine and wait for it to exit. Safe to call many times.
+func (w *writeBehind) close() {
+	w.once.Do(func() {
+		close(w.stop)
+	})
+	<-w.done
+}
+
+// Write the items set in the cache to a backing store asynchronously,
+// in batches. Set only records the key as dirty, a background
+// goroutine calls `flush` with up to `batchSize` items every
+// `flushInterval`, or as soon as `batchSize` keys are dirty. Only the
+// latest value of a key is flushed, items evicted before their flush
+// are flushed all the same. Deletions are not written.
+//
+// A failed batch is retried with exponential backoff, starting at
+// `flushInterval` and doubling up to 64 times it, items are never
+// dropped. Close makes one last attempt to flush everything. The
+// goroutine lives until Close is called.
+func WithWriteBehind(flushInterval time.Duration, batchSize int, flush func([]KeyValue) error) Option {
+	return func(b *LRUCache) {
+		if flushInterval > 0 && batchSize > 0 {
+			b.writeBehind = &writeBehind{
+				interval:  flushInterval,
+				batchSize: batchSize,
+				flush:     flush,
+				pending:   make(map[string]interface{}),
+				kick:      make(chan struct{}, 1),
+				stop:      make(chan struct{}),
+				done:      make(chan struct{}),
+			}
+		}
+	}
+}
+
+// Number of dirty items waiting to be flushed by WithWriteBehind.
+func (b *LRUCache) PendingFlush() int {
+	if b.writeBehind == nil {
+		return 0
+	}
+	b.writeBehind.lock.Lock()
+	defer b.writeBehind.lock.Unlock()
+	return len(b.writeBehind.order)
+}
@@ -0,0 +1,65 @@
+package lrucache
+
+import (
+	"context"
+	"time"
+)
+
+// Write the items set by Set, SetNow, SetDefault, SetCtx and SetClass
+// to a backing store before adding them to the cache. These calls run
+// `store` without the lock held and fail with its error, leaving the
+// cache unchanged. The other writes skip the store: items added by
+// the loader, Fetch, Load and SetLazy, the counters of IncrementInt64
+// and IncrementCapped, the lists of Append and TrimList, ReplaceAll,
+// TouchOrSet, Tx.Set, changes made through an EntryHandle, WarmFrom,
+// and the items moved by MoveTo and MoveEntry.
+func WithWriteThrough(store func(ctx context.Context, key string, value interface{}, expire time.Time) error) Option {
+	return func(b *LRUCache) {
+		b.writeThrough = store
+	}
+}
+
+// Set passing `ctx` to the WithWriteThrough function. Returns
+// ctx.Err() without adding the item if the context is done before the
+// store completes, even if the store ignores the context. Without
+// write-through the context is ignored.
+func (b *LRUCache) SetCtx(ctx context.Context, key string, value interface{}, expire time.Time) error {
+	if b.writeThrough != nil {
+		if err := b.store(ctx, key, value, expire); err != nil {
+			return err
+		}
+	}
+	return b.insert(key, value, expire, time.Time{})
+}
+
+// Run the write-through function, giving up when `ctx` is done.
+// Nothing is written while draining.
+func (b *LRUCache) store(ctx context.Context, key string, value interface{}, expire time.Time) error {
+	if b.draining.Load() {
+		return ErrDraining
+	}
+	if b.failFrozen && b.Frozen() {
+		return ErrFrozen
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	call := func() (err error) {
+		if perr := b.protect("WriteThrough", func() { err = b.writeThrough(ctx, key, value, expire) }); perr != nil {
+			err = perr
+		}
+		return err
+	}
+	if ctx.Done() == nil {
+		return call()
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- call() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
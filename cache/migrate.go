@@ -0,0 +1,41 @@
+package cache
+
+import (
+	"time"
+)
+
+// Copy the items of `src` to `dst` keeping their remaining TTL, for
+// example to move warm data to a cache of a different size or kind.
+// Keys are copied in reverse src.Keys() order, least recently used
+// first for an LRUCache, so if `dst` is too small the most recent
+// items are the ones that stay. Stale items are skipped. Items
+// written to `src` during the migration may be copied with a mismatched
+// value and TTL, stop the writers first. Returns the number of items
+// set in `dst`, which is more than dst.Len() if some were pushed out.
+func Migrate(src, dst Cache) int {
+	now := time.Now()
+	keys := src.Keys()
+	n := 0
+	for i := len(keys) - 1; i >= 0; i-- {
+		key := keys[i]
+		ttl, ok := src.TTL(key)
+		if !ok {
+			continue
+		}
+		value, ok := src.GetQuiet(key)
+		if !ok {
+			continue
+		}
+		var expire time.Time
+		if ttl != NoExpiry {
+			if ttl <= 0 {
+				continue
+			}
+			expire = now.Add(ttl)
+		}
+		if dst.Set(key, value, expire) == nil {
+			n += 1
+		}
+	}
+	return n
+}
@@ -1,9 +1,16 @@
 package multilru
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"github.com/majek/goplayground/cache/lrucache"
 	"hash"
 	"hash/crc32"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -11,58 +18,159 @@ type MultiLRUCache struct {
 	buckets uint
 	cache   []*lrucache.LRUCache
 	hash    hash.Hash
+
+	// Keys are spread over slots by hash. A slot starts with a single
+	// bucket, SplitHottest splits one bucket of a slot in two.
+	//
+	// Locks are taken in this order: lock, then the slot locks, then
+	// the bucket locks in increasing bucket number. Buckets are
+	// numbered in the order they were created in, which is the order
+	// lrucache.MoveTo and MoveEntry lock two caches in.
+	lock           sync.RWMutex // held for writing while splitting, guards cache and all
+	all            []*bucket    // all the buckets, all[i].cache == cache[i]
+	slots          []slot
+	bucketCapacity uint
+	options        []lrucache.Option
+	frozen         atomic.Bool // set by Freeze, changed with lock held for reading
+	draining       atomic.Bool // set by Drain, applied to the buckets SplitHottest adds
+}
+
+// The leaves of a slot are indexed by the low bits of the key hash,
+// like the directory of extendible hashing: a bucket of depth d is at
+// every index sharing its low d bits.
+type slot struct {
+	lock   sync.RWMutex // held for reading while a bucket is used
+	leaves []*bucket    // power of two long
 }
 
+type bucket struct {
+	ops   atomic.Uint64 // operations since the last split
+	cache *lrucache.LRUCache
+	no    uint // index in MultiLRUCache.cache
+	slot  uint
+	depth uint // low bits of the leaf index it owns
+}
 
 // Using this constructor is almost always wrong. Use NewMultiLRUCache instead.
-func (m *MultiLRUCache) Init(buckets, bucket_capacity uint) {
+func (m *MultiLRUCache) Init(buckets, bucket_capacity uint, options ...lrucache.Option) {
 	m.buckets = buckets
-	m.cache = make([]*lrucache.LRUCache, buckets)
+	m.bucketCapacity = bucket_capacity
+	m.options = options
+	m.cache = make([]*lrucache.LRUCache, 0, buckets)
+	m.slots = make([]slot, buckets)
 	for i := uint(0); i < buckets; i++ {
-		m.cache[i] = lrucache.NewLRUCache(bucket_capacity)
+		m.slots[i].leaves = []*bucket{m.newBucket(i)}
 	}
 }
 
-func NewMultiLRUCache(buckets, bucket_capacity uint) *MultiLRUCache {
+// Create a bucket and add it to the cache.
+func (m *MultiLRUCache) newBucket(slot uint) *bucket {
+	bk := &bucket{
+		cache: lrucache.NewLRUCache(m.bucketCapacity, m.options...),
+		no:    uint(len(m.cache)),
+		slot:  slot,
+	}
+	m.cache = append(m.cache, bk.cache)
+	m.all = append(m.all, bk)
+	return bk
+}
+
+// Options are applied to every bucket separately.
+func NewMultiLRUCache(buckets, bucket_capacity uint, options ...lrucache.Option) *MultiLRUCache {
 	m := &MultiLRUCache{}
-	m.Init(buckets, bucket_capacity)
+	m.Init(buckets, bucket_capacity, options...)
 	return m
 }
 
+// Bucket for the key. The placement is deterministic: crc32 (IEEE)
+// of the key modulo the number of buckets gives the same answer in
+// every process, on every platform and in every version of this
+// package, so a distributed layer may rely on it. Changing the hash
+// would break that guarantee and must be treated as a breaking change.
+//
+// Once a slot is split the placement of its keys depends on the
+// splits done so far. Must be called with the slot of the key locked,
+// or when there are no concurrent splits.
 func (m *MultiLRUCache) bucketNo(key string) uint {
-	// Arbitrary choice. Any fast hash will do.
-	return uint(crc32.ChecksumIEEE([]byte(key))) % m.buckets
+	s, leaf := m.place(key)
+	return s.leaves[leaf].no
+}
+
+// Slot of the key and the index of its bucket within the slot.
+func (m *MultiLRUCache) place(key string) (*slot, uint) {
+	// Part of the placement guarantee, see bucketNo.
+	h := uint(crc32.ChecksumIEEE([]byte(key)))
+	s := &m.slots[h%m.buckets]
+	return s, leafNo(h/m.buckets, len(s.leaves))
+}
+
+func leafNo(h uint, leaves int) uint {
+	return h & uint(leaves-1)
+}
+
+// Bucket for the key, with its slot locked for reading. The caller
+// must RUnlock the slot when done with the bucket.
+func (m *MultiLRUCache) bucket(key string) (*lrucache.LRUCache, *slot) {
+	h := uint(crc32.ChecksumIEEE([]byte(key)))
+	s := &m.slots[h%m.buckets]
+	s.lock.RLock()
+	bk := s.leaves[leafNo(h/m.buckets, len(s.leaves))]
+	bk.ops.Add(1)
+	return bk.cache, s
+}
+
+func (m *MultiLRUCache) Set(key string, value interface{}, expire time.Time) error {
+	c, s := m.bucket(key)
+	defer s.lock.RUnlock()
+	return c.Set(key, value, expire)
 }
 
-func (m *MultiLRUCache) Set(key string, value interface{}, expire time.Time) {
-	m.cache[m.bucketNo(key)].Set(key, value, expire)
+func (m *MultiLRUCache) SetNow(key string, value interface{}, expire time.Time, now time.Time) error {
+	c, s := m.bucket(key)
+	defer s.lock.RUnlock()
+	return c.SetNow(key, value, expire, now)
 }
 
-func (m *MultiLRUCache) SetNow(key string, value interface{}, expire time.Time, now time.Time) {
-	m.cache[m.bucketNo(key)].SetNow(key, value, expire, now)
+func (m *MultiLRUCache) SetCtx(ctx context.Context, key string, value interface{}, expire time.Time) error {
+	c, s := m.bucket(key)
+	defer s.lock.RUnlock()
+	return c.SetCtx(ctx, key, value, expire)
 }
 
 func (m *MultiLRUCache) Get(key string) (value interface{}, ok bool) {
-	return m.cache[m.bucketNo(key)].Get(key)
+	c, s := m.bucket(key)
+	defer s.lock.RUnlock()
+	return c.Get(key)
 }
 
 func (m *MultiLRUCache) GetQuiet(key string) (value interface{}, ok bool) {
-	return m.cache[m.bucketNo(key)].Get(key)
+	c, s := m.bucket(key)
+	defer s.lock.RUnlock()
+	return c.GetQuiet(key)
 }
 
 func (m *MultiLRUCache) GetNotStale(key string) (value interface{}, ok bool) {
-	return m.cache[m.bucketNo(key)].GetNotStale(key)
+	c, s := m.bucket(key)
+	defer s.lock.RUnlock()
+	return c.GetNotStale(key)
 }
 
 func (m *MultiLRUCache) GetNotStaleNow(key string, now time.Time) (value interface{}, ok bool) {
-	return m.cache[m.bucketNo(key)].GetNotStaleNow(key, now)
+	c, s := m.bucket(key)
+	defer s.lock.RUnlock()
+	return c.GetNotStaleNow(key, now)
 }
 
 func (m *MultiLRUCache) Del(key string) (value interface{}, ok bool) {
-	return m.cache[m.bucketNo(key)].Del(key)
+	c, s := m.bucket(key)
+	defer s.lock.RUnlock()
+	return c.Del(key)
 }
 
 func (m *MultiLRUCache) Clear() int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
 	var s int
 	for _, c := range m.cache {
 		s += c.Clear()
@@ -70,7 +178,57 @@ func (m *MultiLRUCache) Clear() int {
 	return s
 }
 
+// Drain every bucket, see lrucache.Drain, including the ones
+// SplitHottest adds later. The channel is closed once all the buckets
+// are empty. Every call returns a new channel.
+func (m *MultiLRUCache) Drain() <-chan struct{} {
+	m.lock.RLock()
+	m.draining.Store(true)
+	for _, c := range m.cache {
+		c.Drain()
+	}
+	m.lock.RUnlock()
+
+	done := make(chan struct{})
+	go func() {
+		// Buckets added by SplitHottest in the meantime are waited
+		// for as well. Once all are empty, splits only add empty ones.
+		for n := 0; ; {
+			m.lock.RLock()
+			var chans []<-chan struct{}
+			for _, c := range m.cache[n:] {
+				chans = append(chans, c.Drain())
+			}
+			n = len(m.cache)
+			m.lock.RUnlock()
+			if len(chans) == 0 {
+				close(done)
+				return
+			}
+			for _, ch := range chans {
+				<-ch
+			}
+		}
+	}()
+	return done
+}
+
+func (m *MultiLRUCache) Drained() bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for _, c := range m.cache {
+		if !c.Drained() {
+			return false
+		}
+	}
+	return true
+}
+
 func (m *MultiLRUCache) Len() int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
 	var s int
 	for _, c := range m.cache {
 		s += c.Len()
@@ -79,6 +237,9 @@ func (m *MultiLRUCache) Len() int {
 }
 
 func (m *MultiLRUCache) Capacity() int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
 	var s int
 	for _, c := range m.cache {
 		s += c.Capacity()
@@ -87,6 +248,9 @@ func (m *MultiLRUCache) Capacity() int {
 }
 
 func (m *MultiLRUCache) Expire() int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
 	var s int
 	for _, c := range m.cache {
 		s += c.Expire()
@@ -95,9 +259,589 @@ func (m *MultiLRUCache) Expire() int {
 }
 
 func (m *MultiLRUCache) ExpireNow(now time.Time) int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
 	var s int
 	for _, c := range m.cache {
 		s += c.ExpireNow(now)
 	}
 	return s
 }
+
+func (m *MultiLRUCache) ExpireClassNow(class int, now time.Time) int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var s int
+	for _, c := range m.cache {
+		s += c.ExpireClassNow(class, now)
+	}
+	return s
+}
+
+func (m *MultiLRUCache) SetClass(key string, value interface{}, expire time.Time, class int) error {
+	c, s := m.bucket(key)
+	defer s.lock.RUnlock()
+	return c.SetClass(key, value, expire, class)
+}
+
+// Recent evictions from all the buckets, oldest first. Every bucket
+// keeps its own log, so up to buckets*size records are returned.
+func (m *MultiLRUCache) RecentEvictions() []lrucache.EvictionRecord {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var r []lrucache.EvictionRecord
+	for _, c := range m.cache {
+		r = append(r, c.RecentEvictions()...)
+	}
+	sort.SliceStable(r, func(i, j int) bool {
+		return r[i].Time.Before(r[j].Time)
+	})
+	return r
+}
+
+// Replace the content of every bucket. The replacement is atomic
+// within a bucket but not across buckets: a reader may briefly see
+// some buckets already replaced and others not yet.
+func (m *MultiLRUCache) ReplaceAll(entries map[string]lrucache.ValueExpire) int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	parts := make([]map[string]lrucache.ValueExpire, len(m.cache))
+	for i := range parts {
+		parts[i] = make(map[string]lrucache.ValueExpire)
+	}
+	for key, ve := range entries {
+		parts[m.bucketNo(key)][key] = ve
+	}
+
+	var s int
+	for i, c := range m.cache {
+		s += c.ReplaceAll(parts[i])
+	}
+	return s
+}
+
+func (m *MultiLRUCache) ExpiredKeys(now time.Time) []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var keys []string
+	for _, c := range m.cache {
+		keys = append(keys, c.ExpiredKeys(now)...)
+	}
+	return keys
+}
+
+func (m *MultiLRUCache) Append(key string, value interface{}, expire time.Time) int {
+	c, s := m.bucket(key)
+	defer s.lock.RUnlock()
+	return c.Append(key, value, expire)
+}
+
+func (m *MultiLRUCache) IncrementInt64(key string, delta int64, expire time.Time) int64 {
+	c, s := m.bucket(key)
+	defer s.lock.RUnlock()
+	return c.IncrementInt64(key, delta, expire)
+}
+
+func (m *MultiLRUCache) IncrementCapped(key string, delta, max int64, expire time.Time) (int64, bool) {
+	c, s := m.bucket(key)
+	defer s.lock.RUnlock()
+	return c.IncrementCapped(key, delta, max, expire)
+}
+
+func (m *MultiLRUCache) GetList(key string) (values []interface{}, ok bool) {
+	c, s := m.bucket(key)
+	defer s.lock.RUnlock()
+	return c.GetList(key)
+}
+
+func (m *MultiLRUCache) TrimList(key string, max int) int {
+	c, s := m.bucket(key)
+	defer s.lock.RUnlock()
+	return c.TrimList(key, max)
+}
+
+// Stop background goroutines of all the buckets, for example the ones
+// started by lrucache.WithJanitor, and wait for them to finish. Without
+// Close every bucket leaks its goroutines. Idempotent. The cache must
+// not be used after Close.
+func (m *MultiLRUCache) Close() {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for _, c := range m.cache {
+		c.Close()
+	}
+}
+
+func (m *MultiLRUCache) GetWithRefreshHint(key string, refreshAhead time.Duration) (value interface{}, shouldRefresh bool, ok bool) {
+	c, s := m.bucket(key)
+	defer s.lock.RUnlock()
+	return c.GetWithRefreshHint(key, refreshAhead)
+}
+
+func (m *MultiLRUCache) GetWithRefreshHintNow(key string, refreshAhead time.Duration, now time.Time) (value interface{}, shouldRefresh bool, ok bool) {
+	c, s := m.bucket(key)
+	defer s.lock.RUnlock()
+	return c.GetWithRefreshHintNow(key, refreshAhead, now)
+}
+
+// Get a key, possibly stale, together with the bucket it lives in
+// and its depth in that bucket's LRU list (0 is the most recently
+// used). Doesn't modify the LRU score. Meant for studying hot key
+// concentration, not for the hot path: O(bucket size).
+func (m *MultiLRUCache) GetWithLocality(key string) (value interface{}, bucket uint, depthFromFront int, ok bool) {
+	c, s := m.bucket(key)
+	defer s.lock.RUnlock()
+	bucket = m.bucketNo(key)
+	value, depthFromFront, ok = c.GetWithDepth(key)
+	return value, bucket, depthFromFront, ok
+}
+
+// Approximately the `n` most recently used keys across all buckets.
+// Buckets don't share a clock, so there is no global recency order:
+// the most recent key of every bucket comes first, then the second
+// most recent of every bucket, and so on, round-robin. Within a bucket
+// the order is exact.
+func (m *MultiLRUCache) MostRecentlyUsed(n int) []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	fronts := make([][]string, len(m.cache))
+	for i, c := range m.cache {
+		fronts[i] = c.MostRecentlyUsed(n)
+	}
+
+	keys := make([]string, 0, n)
+	for rank := 0; rank < n && len(keys) < n; rank++ {
+		found := false
+		for _, f := range fronts {
+			if rank < len(f) && len(keys) < n {
+				keys = append(keys, f[rank])
+				found = true
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	return keys
+}
+
+func (m *MultiLRUCache) SetLazy(key string, init func() interface{}, expire time.Time) error {
+	c, s := m.bucket(key)
+	defer s.lock.RUnlock()
+	return c.SetLazy(key, init, expire)
+}
+
+// Freeze every bucket, see lrucache.Freeze. Buckets are frozen one
+// after another, once Freeze returns all of them are frozen.
+func (m *MultiLRUCache) Freeze() {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	m.frozen.Store(true)
+	for _, c := range m.cache {
+		c.Freeze()
+	}
+}
+
+func (m *MultiLRUCache) Unfreeze() {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	m.frozen.Store(false)
+	for _, c := range m.cache {
+		c.Unfreeze()
+	}
+}
+
+func (m *MultiLRUCache) GetWithRefresh(key string, mode lrucache.RefreshMode) (value interface{}, err error) {
+	c, s := m.bucket(key)
+	defer s.lock.RUnlock()
+	return c.GetWithRefresh(key, mode)
+}
+
+// Drain every bucket, see lrucache.ClearAndReturn. Each bucket is
+// drained atomically, but not all of them at once.
+func (m *MultiLRUCache) ClearAndReturn() []lrucache.KeyValue {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var items []lrucache.KeyValue
+	for _, c := range m.cache {
+		items = append(items, c.ClearAndReturn()...)
+	}
+	return items
+}
+
+// Keys of all the items, bucket by bucket.
+func (m *MultiLRUCache) Keys() []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var keys []string
+	for _, c := range m.cache {
+		keys = append(keys, c.Keys()...)
+	}
+	return keys
+}
+
+// Keys of all the buckets filling `dst`, see lrucache.KeysInto.
+func (m *MultiLRUCache) KeysInto(dst []string) []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	keys := dst[:0]
+	for _, c := range m.cache {
+		n := len(keys)
+		keys = append(keys[:n], c.KeysInto(keys[n:])...)
+	}
+	return keys
+}
+
+// Range over the buckets one after another, each with its own lock
+// held, see lrucache.UnsafeRange for the rules `fn` must follow.
+func (m *MultiLRUCache) UnsafeRange(fn func(key string, value interface{}, expire time.Time)) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for _, c := range m.cache {
+		c.UnsafeRange(fn)
+	}
+}
+
+// Iterator over all the items with the same snapshot semantics as
+// lrucache.Iterator. Keys are visited bucket by bucket.
+func (m *MultiLRUCache) Iterator() *lrucache.EntryIterator {
+	return lrucache.NewEntryIterator(m.Keys(), m.GetQuiet)
+}
+
+func (m *MultiLRUCache) DelIf(key string, pred func(value interface{}) bool) (deleted bool) {
+	c, s := m.bucket(key)
+	defer s.lock.RUnlock()
+	return c.DelIf(key, pred)
+}
+
+// Priority queue stats of all the buckets combined. HeadExpire is the
+// earliest of the bucket heads.
+func (m *MultiLRUCache) PriorityQueueStats() lrucache.PriorityQueueStats {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var s lrucache.PriorityQueueStats
+	for _, c := range m.cache {
+		cs := c.PriorityQueueStats()
+		if cs.Len == 0 {
+			continue
+		}
+		if s.Len == 0 || cs.MinExpire.Before(s.MinExpire) {
+			s.MinExpire = cs.MinExpire
+			s.HeadExpire = cs.HeadExpire
+		}
+		if cs.MaxExpire.After(s.MaxExpire) {
+			s.MaxExpire = cs.MaxExpire
+		}
+		s.Len += cs.Len
+	}
+	return s
+}
+
+func (m *MultiLRUCache) SetDefaultTTL(ttl time.Duration) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for _, c := range m.cache {
+		c.SetDefaultTTL(ttl)
+	}
+}
+
+func (m *MultiLRUCache) DefaultTTL() time.Duration {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.cache[0].DefaultTTL()
+}
+
+func (m *MultiLRUCache) SetDefault(key string, value interface{}) error {
+	c, s := m.bucket(key)
+	defer s.lock.RUnlock()
+	return c.SetDefault(key, value)
+}
+
+func (m *MultiLRUCache) TouchOrSet(key string, value interface{}, expire time.Time) (existed bool, err error) {
+	c, s := m.bucket(key)
+	defer s.lock.RUnlock()
+	return c.TouchOrSet(key, value, expire)
+}
+
+// Touch the keys, see lrucache.TouchMulti. Keys are grouped by
+// bucket, every bucket is locked once.
+func (m *MultiLRUCache) TouchMulti(keys []string) int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	parts := make([][]string, len(m.cache))
+	for _, key := range keys {
+		no := m.bucketNo(key)
+		parts[no] = append(parts[no], key)
+	}
+
+	var s int
+	for i, c := range m.cache {
+		if len(parts[i]) > 0 {
+			s += c.TouchMulti(parts[i])
+		}
+	}
+	return s
+}
+
+// Get the keys, see lrucache.GetOrdered. Keys are grouped by bucket,
+// every bucket is locked once, and the results put back in the order
+// of `keys`.
+func (m *MultiLRUCache) GetOrdered(keys []string) (values []interface{}, found []bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	parts := make([][]string, len(m.cache))
+	positions := make([][]int, len(m.cache))
+	for i, key := range keys {
+		no := m.bucketNo(key)
+		parts[no] = append(parts[no], key)
+		positions[no] = append(positions[no], i)
+	}
+
+	values = make([]interface{}, len(keys))
+	found = make([]bool, len(keys))
+	for i, c := range m.cache {
+		if len(parts[i]) == 0 {
+			continue
+		}
+		v, ok := c.GetOrdered(parts[i])
+		for j, pos := range positions[i] {
+			values[pos], found[pos] = v[j], ok[j]
+		}
+	}
+	return values, found
+}
+
+// See lrucache.GetFreshReaping. Only the bucket of the key is reaped.
+func (m *MultiLRUCache) GetFreshReaping(key string, now time.Time, maxReap int) (value interface{}, ok bool, reaped int) {
+	c, s := m.bucket(key)
+	defer s.lock.RUnlock()
+	return c.GetFreshReaping(key, now, maxReap)
+}
+
+// Sum of the peaks of the buckets. The buckets may have peaked at
+// different times, so this is an upper bound of the peak Len of the
+// whole cache, not the peak itself.
+func (m *MultiLRUCache) PeakLen() int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var s int
+	for _, c := range m.cache {
+		s += c.PeakLen()
+	}
+	return s
+}
+
+func (m *MultiLRUCache) ResetPeakLen() {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for _, c := range m.cache {
+		c.ResetPeakLen()
+	}
+}
+
+// Histogram of all the buckets summed, see lrucache.TTLHistogram.
+func (m *MultiLRUCache) TTLHistogram(now time.Time, buckets []time.Duration) []int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	counts := make([]int, len(buckets)+2)
+	for _, c := range m.cache {
+		for i, n := range c.TTLHistogram(now, buckets) {
+			counts[i] += n
+		}
+	}
+	return counts
+}
+
+// Recently missed keys of all the buckets, bucket by bucket. Every
+// bucket keeps its own ring, so up to buckets*size keys are returned.
+func (m *MultiLRUCache) RecentMisses() []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var keys []string
+	for _, c := range m.cache {
+		keys = append(keys, c.RecentMisses()...)
+	}
+	return keys
+}
+
+func (m *MultiLRUCache) Fetch(key string, ttl time.Duration, loader func() (interface{}, error)) (interface{}, error) {
+	c, s := m.bucket(key)
+	defer s.lock.RUnlock()
+	return c.Fetch(key, ttl, loader)
+}
+
+// Export the buckets one after another, see lrucache.ExportNDJSON.
+func (m *MultiLRUCache) ExportNDJSON(w io.Writer) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for _, c := range m.cache {
+		if err := c.ExportNDJSON(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Split the stream by bucket and warm every bucket with its part, see
+// lrucache.WarmFrom.
+func (m *MultiLRUCache) WarmFrom(r io.Reader) (loaded, skipped int, err error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	parts := make([]bytes.Buffer, len(m.cache))
+	dec := json.NewDecoder(r)
+	for {
+		var line json.RawMessage
+		if err = dec.Decode(&line); err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, 0, err
+		}
+		var item struct {
+			Key string `json:"key"`
+		}
+		if err = json.Unmarshal(line, &item); err != nil {
+			return 0, 0, err
+		}
+		part := &parts[m.bucketNo(item.Key)]
+		part.Write(line)
+		part.WriteByte('\n')
+	}
+	for i, c := range m.cache {
+		l, s, err := c.WarmFrom(&parts[i])
+		loaded += l
+		skipped += s
+		if err != nil {
+			return loaded, skipped, err
+		}
+	}
+	return loaded, skipped, nil
+}
+
+func (m *MultiLRUCache) TTL(key string) (ttl time.Duration, ok bool) {
+	c, s := m.bucket(key)
+	defer s.lock.RUnlock()
+	return c.TTL(key)
+}
+
+func (m *MultiLRUCache) GetFresh(key string) (value interface{}, ok bool) {
+	c, s := m.bucket(key)
+	defer s.lock.RUnlock()
+	return c.GetFresh(key)
+}
+
+// Counters of all the buckets summed, see lrucache.Stats.
+func (m *MultiLRUCache) Stats() lrucache.Stats {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	s := lrucache.Stats{Removals: make(map[lrucache.EvictReason]uint64)}
+	for _, c := range m.cache {
+		cs := c.Stats()
+		for r, n := range cs.Removals {
+			s.Removals[r] += n
+		}
+		s.Hits += cs.Hits
+		s.Misses += cs.Misses
+		s.Sampled = s.Sampled || cs.Sampled
+		s.EvictionScans += cs.EvictionScans
+		s.Demotions += cs.Demotions
+		s.Rejections += cs.Rejections
+	}
+	return s
+}
+
+// Counters of the diagnostic structures of all the buckets together,
+// see lrucache.Diagnostics.
+func (m *MultiLRUCache) Diagnostics() lrucache.Diagnostics {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var d lrucache.Diagnostics
+	add := func(sum *lrucache.DiagnosticStats, s lrucache.DiagnosticStats) {
+		sum.Overwritten += s.Overwritten
+		sum.Dropped += s.Dropped
+	}
+	for _, c := range m.cache {
+		cd := c.Diagnostics()
+		add(&d.Misses, cd.Misses)
+		add(&d.Ghost, cd.Ghost)
+		add(&d.Evictions, cd.Evictions)
+	}
+	return d
+}
+
+// Hit rate over the last `window` of all the buckets together, see
+// lrucache.WindowedHitRate.
+func (m *MultiLRUCache) WindowedHitRate(window time.Duration) float64 {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var hits, misses uint64
+	for _, c := range m.cache {
+		h, n := c.WindowedHits(window)
+		hits, misses = hits+h, misses+n
+	}
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+func (m *MultiLRUCache) PendingFlush() int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var s int
+	for _, c := range m.cache {
+		s += c.PendingFlush()
+	}
+	return s
+}
+
+// See lrucache.Acquire. SplitHottest may move a pinned item to
+// another bucket, its handle then behaves as if it was deleted.
+func (m *MultiLRUCache) Acquire(key string) (*lrucache.EntryHandle, bool) {
+	c, s := m.bucket(key)
+	defer s.lock.RUnlock()
+	return c.Acquire(key)
+}
+
+// Ghost hit rate of all the buckets, see lrucache.GhostHitRate.
+func (m *MultiLRUCache) GhostHitRate() float64 {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var hits, misses uint64
+	for _, c := range m.cache {
+		h, n := c.GhostHits()
+		hits, misses = hits+h, misses+n
+	}
+	if misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(misses)
+}
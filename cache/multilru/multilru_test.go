@@ -2,6 +2,9 @@ package multilru
 
 import (
 	"github.com/majek/goplayground/cache"
+	"github.com/majek/goplayground/cache/lrucache"
+	"github.com/majek/goplayground/cache/internal/workload"
+	"fmt"
 	"testing"
 	"time"
 	"math/rand"
@@ -96,6 +99,20 @@ func TestBasic(t *testing.T) {
 }
 
 
+func TestGetQuiet(t *testing.T) {
+	t.Parallel()
+	m := NewMultiLRUCache(1, 2)
+
+	m.Set("a", "va", time.Time{})
+	m.Set("b", "vb", time.Time{})
+	if v, ok := m.GetQuiet("a"); !ok || v != "va" {
+		t.Error("expecting hit")
+	}
+	m.Set("c", "vc", time.Time{})
+	if _, ok := m.GetQuiet("a"); ok {
+		t.Error("expecting GetQuiet not to update the LRU order")
+	}
+}
 
 func randomString(l int) string {
 	bytes := make([]byte, l)
@@ -171,3 +188,686 @@ func BenchmarkConcurrentSetNX(bb *testing.B) {
 		_ = <-ch
 	}
 }
+
+func TestEvictionLog(t *testing.T) {
+	t.Parallel()
+	m := NewMultiLRUCache(4, 2, lrucache.WithEvictionLog(10))
+
+	for c := 'a'; c < 'z'; c = rune(int(c) + 1) {
+		m.Set(string(c), string([]rune{'v', c}), time.Time{})
+	}
+	r := m.RecentEvictions()
+	if len(r) != 25-m.Len() {
+		t.Error("expecting different number of evictions", len(r))
+	}
+	for i := 1; i < len(r); i++ {
+		if r[i].Time.Before(r[i-1].Time) {
+			t.Error("expecting records ordered by time")
+		}
+	}
+}
+
+func TestReplaceAll(t *testing.T) {
+	t.Parallel()
+	m := NewMultiLRUCache(2, 3)
+
+	m.Set("a", "va", time.Time{})
+	n := m.ReplaceAll(map[string]lrucache.ValueExpire{
+		"b": {Value: "vb"},
+		"c": {Value: "vc"},
+	})
+	if n != 2 || m.Len() != 2 {
+		t.Error("expecting different length")
+	}
+	if _, ok := m.Get("a"); ok {
+		t.Error("expecting miss")
+	}
+	if v, _ := m.Get("c"); v != "vc" {
+		t.Error("expecting hit")
+	}
+}
+
+func TestExpiredKeys(t *testing.T) {
+	t.Parallel()
+	m := NewMultiLRUCache(4, 10)
+
+	past := time.Now().Add(-time.Second)
+	for c := 'a'; c < 'k'; c = rune(int(c) + 1) {
+		m.Set(string(c), "v", past)
+	}
+	m.Set("z", "vz", time.Time{})
+
+	if len(m.ExpiredKeys(time.Now())) != 10 {
+		t.Error("expecting ten expired keys")
+	}
+	if m.Len() != 11 {
+		t.Error("expecting nothing evicted")
+	}
+}
+
+func TestMultimap(t *testing.T) {
+	t.Parallel()
+	m := NewMultiLRUCache(2, 3)
+
+	m.Append("a", 1, time.Time{})
+	m.Append("a", 2, time.Time{})
+	m.Append("a", 3, time.Time{})
+	m.TrimList("a", 2)
+	if l, ok := m.GetList("a"); !ok || len(l) != 2 || l[0] != 2 {
+		t.Error("expecting list", l)
+	}
+}
+
+func TestClose(t *testing.T) {
+	// Not parallel, counts goroutines.
+	before := runtime.NumGoroutine()
+	m := NewMultiLRUCache(16, 2, lrucache.WithJanitor(time.Millisecond))
+	if runtime.NumGoroutine() < before+16 {
+		t.Error("expecting a janitor per bucket")
+	}
+	m.Close()
+	m.Close()
+	// The janitors are done, their goroutines are about to exit.
+	for i := 0; runtime.NumGoroutine() > before && i < 100; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Error("expecting Close to stop the janitors", n, before)
+	}
+}
+
+// Verify that a hash function gives the expected, precomputed, output
+// and that repeated calls agree. Golden values are what makes the
+// check meaningful across processes and platforms.
+func checkHashStable(t *testing.T, hash func(key string) uint, golden map[string]uint) {
+	for key, expected := range golden {
+		for i := 0; i < 3; i++ {
+			if h := hash(key); h != expected {
+				t.Errorf("unstable hash for %q: got %v expected %v", key, h, expected)
+			}
+		}
+	}
+}
+
+func TestBucketNoDeterministic(t *testing.T) {
+	t.Parallel()
+
+	m := NewMultiLRUCache(16, 1)
+	checkHashStable(t, m.bucketNo, map[string]uint{
+		"":                 0,
+		"a":                3,
+		"hello":            6,
+		"github.com/majek": 6,
+	})
+
+	// Same placement regardless of bucket capacity or instance.
+	n := NewMultiLRUCache(16, 100)
+	for c := 'a'; c < 'z'; c = rune(int(c) + 1) {
+		if m.bucketNo(string(c)) != n.bucketNo(string(c)) {
+			t.Error("expecting same bucket in every instance")
+		}
+	}
+}
+
+func TestGetWithLocality(t *testing.T) {
+	t.Parallel()
+	m := NewMultiLRUCache(4, 10)
+
+	m.Set("a", "va", time.Time{})
+	v, bucket, depth, ok := m.GetWithLocality("a")
+	if v != "va" || bucket != m.bucketNo("a") || depth != 0 || !ok {
+		t.Error("expecting hit at the front of its bucket")
+	}
+	if _, _, _, ok := m.GetWithLocality("miss"); ok {
+		t.Error("expecting miss")
+	}
+}
+
+func TestMostRecentlyUsed(t *testing.T) {
+	t.Parallel()
+	m := NewMultiLRUCache(4, 10)
+
+	for c := 'a'; c < 'k'; c = rune(int(c) + 1) {
+		m.Set(string(c), "v", time.Time{})
+	}
+	m.Get("a")
+
+	keys := m.MostRecentlyUsed(4)
+	if len(keys) != 4 {
+		t.Fatal("expecting four keys", keys)
+	}
+	found := false
+	for _, k := range keys {
+		found = found || k == "a"
+	}
+	if !found {
+		t.Error("expecting recently used key among the first", keys)
+	}
+	if len(m.MostRecentlyUsed(100)) != 10 {
+		t.Error("expecting all keys")
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	t.Parallel()
+	m := NewMultiLRUCache(4, 2)
+
+	m.Set("a", "va", time.Time{})
+	m.Freeze()
+	done := make(chan bool)
+	go func() {
+		m.Del("a")
+		done <- true
+	}()
+	if v, _ := m.Get("a"); v != "va" {
+		t.Error("expecting reads to work")
+	}
+	m.Unfreeze()
+	<-done
+	if _, ok := m.Get("a"); ok {
+		t.Error("expecting del to complete")
+	}
+}
+
+func TestClearAndReturn(t *testing.T) {
+	t.Parallel()
+	m := NewMultiLRUCache(4, 10)
+
+	for c := 'a'; c < 'k'; c = rune(int(c) + 1) {
+		m.Set(string(c), "v", time.Time{})
+	}
+	if len(m.ClearAndReturn()) != 10 || m.Len() != 0 {
+		t.Error("expecting all items drained")
+	}
+}
+
+func TestIterator(t *testing.T) {
+	t.Parallel()
+	m := NewMultiLRUCache(4, 10)
+
+	for c := 'a'; c < 'k'; c = rune(int(c) + 1) {
+		m.Set(string(c), "v"+string(c), time.Time{})
+	}
+	n := 0
+	for it := m.Iterator(); it.Next(); {
+		k, v := it.Current()
+		if v != "v"+k {
+			t.Error("expecting matching value", k, v)
+		}
+		n += 1
+	}
+	if n != 10 || len(m.Keys()) != 10 {
+		t.Error("expecting all items")
+	}
+}
+
+func TestDelIf(t *testing.T) {
+	t.Parallel()
+	m := NewMultiLRUCache(2, 3)
+
+	m.Set("a", "va", time.Time{})
+	if m.DelIf("a", func(v interface{}) bool { return v == "other" }) {
+		t.Error("expecting value to be kept")
+	}
+	if !m.DelIf("a", func(v interface{}) bool { return v == "va" }) {
+		t.Error("expecting value to be deleted")
+	}
+}
+
+func TestPriorityQueueStats(t *testing.T) {
+	t.Parallel()
+	m := NewMultiLRUCache(4, 10)
+
+	base := time.Unix(1000, 0)
+	for i := 1; i <= 10; i++ {
+		m.Set(string(rune('a'+i)), "v", base.Add(time.Duration(i)*time.Second))
+	}
+	s := m.PriorityQueueStats()
+	if s.Len != 10 || !s.MinExpire.Equal(base.Add(time.Second)) ||
+		!s.MaxExpire.Equal(base.Add(10*time.Second)) {
+		t.Error("expecting different stats", s)
+	}
+}
+
+func TestWithLock(t *testing.T) {
+	t.Parallel()
+	m := NewMultiLRUCache(4, 10)
+
+	// "a" and "b" live in different buckets.
+	if m.bucketNo("a") == m.bucketNo("b") {
+		t.Fatal("expecting different buckets")
+	}
+	m.Set("a", "va", time.Time{})
+
+	done := make(chan bool)
+	for i := 0; i < 2; i++ {
+		keys := []string{"a", "b"}
+		if i == 1 {
+			keys = []string{"b", "a"}
+		}
+		go func() {
+			for j := 0; j < 100; j++ {
+				m.WithLock(keys, func(tx *Tx) {
+					if v, ok := tx.Del("a"); ok {
+						tx.Set("b", v, time.Time{})
+					} else if v, ok := tx.Del("b"); ok {
+						tx.Set("a", v, time.Time{})
+					}
+				})
+			}
+			done <- true
+		}()
+	}
+	<-done
+	<-done
+	if m.Len() != 1 {
+		t.Error("expecting value to be moved atomically")
+	}
+
+	r := rec(func() {
+		m.WithLock([]string{"a"}, func(tx *Tx) { tx.Get("b") })
+	})
+	if r != 1 {
+		t.Error("expecting panic for key outside of the locked buckets")
+	}
+}
+
+func rec(foo func()) (recovered int) {
+	defer func() {
+		if r := recover(); r != nil {
+			recovered += 1
+		}
+	}()
+	foo()
+	return recovered
+}
+
+func TestSplitHottest(t *testing.T) {
+	t.Parallel()
+
+	m := NewMultiLRUCache(2, 100)
+	if m.SplitHottest() {
+		t.Error("expecting no split without operations")
+	}
+
+	// All the hot keys land in bucket 0.
+	var hot []string
+	for i := 0; len(hot) < 50; i++ {
+		if key := fmt.Sprint(i); m.bucketNo(key) == 0 {
+			hot = append(hot, key)
+		}
+	}
+	workload := func() uint64 {
+		for _, key := range hot {
+			m.Set(key, key, time.Time{})
+			m.Get(key)
+		}
+		var max uint64
+		for _, l := range m.Loads() {
+			if l > max {
+				max = l
+			}
+		}
+		return max
+	}
+
+	before := workload()
+	if !m.SplitHottest() {
+		t.Error("expecting split")
+	}
+	if len(m.Loads()) != 3 || m.Capacity() != 300 {
+		t.Error("expecting one new bucket")
+	}
+	for _, key := range hot {
+		// Straight to the bucket, not to count as load.
+		if v, ok := m.cache[m.bucketNo(key)].GetQuiet(key); !ok || v.(string) != key {
+			t.Error("expecting items to survive the split")
+		}
+	}
+	for _, l := range m.Loads() {
+		if l != 0 {
+			t.Error("expecting loads to be reset")
+		}
+	}
+	after := workload()
+	if after >= before {
+		t.Errorf("expecting max bucket load to drop, %d >= %d", after, before)
+	}
+	if m.Len() != len(hot) {
+		t.Error("expecting no duplicates")
+	}
+
+	// Only the hottest bucket is split again, the others keep their
+	// keys.
+	other := make(map[string]uint)
+	for i := 0; i < 50; i++ {
+		if key := fmt.Sprint("o", i); m.bucketNo(key) != m.bucketNo(hot[0]) {
+			m.Set(key, key, time.Time{})
+			other[key] = m.bucketNo(key)
+		}
+	}
+	for _, bk := range m.all {
+		bk.ops.Store(0)
+	}
+	m.Get(hot[0])
+	if !m.SplitHottest() || len(m.Loads()) != 4 {
+		t.Error("expecting one new bucket")
+	}
+	for key, no := range other {
+		if m.bucketNo(key) != no {
+			t.Error("expecting keys of other buckets not to move", key)
+		}
+	}
+	for _, key := range hot {
+		if v, ok := m.GetQuiet(key); !ok || v.(string) != key {
+			t.Error("expecting items to survive the second split")
+		}
+	}
+}
+
+func TestSplitHottestKeepsClassAndPins(t *testing.T) {
+	t.Parallel()
+	m := NewMultiLRUCache(1, 100, lrucache.WithExpiryClasses(0, 0))
+	past := time.Now().Add(-time.Hour)
+	for i := 0; i < 40; i++ {
+		m.SetClass(fmt.Sprint(i), i, past, 1)
+	}
+	var handles []*lrucache.EntryHandle
+	for i := 0; i < 40; i++ {
+		h, _ := m.Acquire(fmt.Sprint(i))
+		handles = append(handles, h)
+	}
+	if !m.SplitHottest() || m.Len() != 40 {
+		t.Fatal("expecting split")
+	}
+	for i, h := range handles {
+		h.SetValue(-i)
+		h.Release()
+	}
+	for i := 0; i < 40; i++ {
+		if v, ok := m.GetQuiet(fmt.Sprint(i)); !ok || v != -i {
+			t.Error("expecting the handles to follow their items", i, v)
+		}
+	}
+	if n := m.ExpireClassNow(1, time.Now()); n != 40 {
+		t.Error("expecting the items to keep their class", n)
+	}
+}
+
+func TestTouchMulti(t *testing.T) {
+	t.Parallel()
+	m := NewMultiLRUCache(2, 2)
+
+	m.Set("a", "va", time.Time{})
+	m.Set("b", "vb", time.Time{})
+	if n := m.TouchMulti([]string{"a", "b", "c"}); n != 2 {
+		t.Error("expecting two existing keys", n)
+	}
+}
+
+func TestRename(t *testing.T) {
+	t.Parallel()
+	m := NewMultiLRUCache(4, 2)
+
+	// Keys in the same bucket and in different ones.
+	var same, other string
+	for i := 0; same == "" || other == ""; i++ {
+		key := fmt.Sprint(i)
+		if m.bucketNo(key) == m.bucketNo("a") && key != "a" {
+			same = key
+		} else if m.bucketNo(key) != m.bucketNo("a") {
+			other = key
+		}
+	}
+
+	m.Set("a", "va", time.Time{})
+	if !m.Rename("a", same) || !m.Rename(same, other) || m.Len() != 1 {
+		t.Error("expecting renames to succeed")
+	}
+	if v, ok := m.Get(other); !ok || v != "va" {
+		t.Error("expecting value to be moved")
+	}
+	if m.Rename("a", "b") {
+		t.Error("expecting no rename of a missing key")
+	}
+
+	// The new bucket takes no items while draining.
+	m.Drain()
+	if m.Rename(other, "a") {
+		t.Error("expecting rename into a draining bucket to fail")
+	}
+	if v, ok := m.GetQuiet(other); !ok || v != "va" {
+		t.Error("expecting item to stay at its key", v)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+	b := lrucache.NewLRUCache(10)
+	m := NewMultiLRUCache(4, 10)
+
+	now := time.Now()
+	for i := 0; i < 8; i++ {
+		b.Set(fmt.Sprint(i), i, now.Add(time.Hour))
+	}
+	b.Set("forever", "v", time.Time{})
+	b.Set("stale", "v", now.Add(-time.Second))
+
+	if n := cache.Migrate(b, m); n != 9 || m.Len() != 9 {
+		t.Error("expecting all fresh items migrated", n)
+	}
+	if ttl, _ := m.TTL("3"); ttl <= 59*time.Minute {
+		t.Error("expecting TTL to be kept", ttl)
+	}
+	if ttl, _ := m.TTL("forever"); ttl != cache.NoExpiry {
+		t.Error("expecting no expiry to be kept", ttl)
+	}
+
+	small := lrucache.NewLRUCache(4)
+	if n := cache.Migrate(b, small); n != 9 || small.Len() != 4 {
+		t.Error("expecting overflow to be pushed out", n)
+	}
+	if _, ok := small.GetQuiet("forever"); !ok {
+		t.Error("expecting most recent items to stay")
+	}
+
+	back := lrucache.NewLRUCache(10)
+	if n := cache.Migrate(m, back); n != 9 {
+		t.Error("expecting migration back", n)
+	}
+}
+
+// Hit rate of a workload whose keys all live in bucket 0 of `m`.
+func skewedHitRate(m *MultiLRUCache) float64 {
+	var keys []string
+	for i := 0; len(keys) < 60; i++ {
+		if key := fmt.Sprint(i); m.bucketNo(key) == 0 {
+			keys = append(keys, key)
+		}
+	}
+	return workload.HitRate(m, 20*len(keys), func(i int) (string, bool) {
+		return keys[i%len(keys)], true
+	})
+}
+
+func TestSharedBudget(t *testing.T) {
+	t.Parallel()
+	size := func(key string, value interface{}) int { return 1 }
+
+	static := skewedHitRate(NewMultiLRUCache(4, 25))
+	budget := lrucache.NewBudget(100)
+	shared := NewMultiLRUCache(4, 100, lrucache.WithSharedBudget(budget, size))
+	if rate := skewedHitRate(shared); rate <= static {
+		t.Error("expecting better hit rate with a shared budget", rate, static)
+	}
+	if budget.Used() != int64(shared.Len()) {
+		t.Error("expecting budget to track the items", budget.Used())
+	}
+
+	for i := 0; i < 1000; i++ {
+		shared.Set(fmt.Sprint("k", i), i, time.Time{})
+	}
+	if shared.Len() > 100 || budget.Used() > budget.Limit() {
+		t.Error("expecting the budget to be kept", shared.Len())
+	}
+	shared.Clear()
+	if budget.Used() != 0 {
+		t.Error("expecting budget to be released", budget.Used())
+	}
+}
+
+func TestGetOrdered(t *testing.T) {
+	t.Parallel()
+	m := NewMultiLRUCache(4, 10)
+	var keys []string
+	for i := 0; i < 20; i++ {
+		keys = append(keys, fmt.Sprint("k", i%7))
+		if i%2 == 0 {
+			m.Set(fmt.Sprint("k", i%7), i%7, time.Time{})
+		}
+	}
+
+	values, found := m.GetOrdered(keys)
+	for i, key := range keys {
+		v, ok := m.GetQuiet(key)
+		if values[i] != v || found[i] != ok {
+			t.Error("expecting result in place of the key", i, key, values[i], found[i])
+		}
+	}
+}
+
+func TestSplitHottestUnderTraffic(t *testing.T) {
+	t.Parallel()
+	m := NewMultiLRUCache(2, 1000)
+	for i := 0; i < 200; i++ {
+		m.Set(fmt.Sprint(i), i, time.Time{})
+	}
+
+	stop := make(chan struct{})
+	done := make(chan bool)
+	for g := 0; g < 4; g++ {
+		go func(g int) {
+			ok := true
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					done <- ok
+					return
+				default:
+				}
+				key := fmt.Sprint((i * 7) % 200)
+				if v, found := m.Get(key); !found || fmt.Sprint(v) != key {
+					ok = false
+				}
+				m.Set(fmt.Sprint("new", g, "-", i%100), i, time.Time{})
+			}
+		}(g)
+	}
+	for i := 0; i < 4; i++ {
+		m.SplitHottest()
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	for g := 0; g < 4; g++ {
+		if !<-done {
+			t.Error("expecting items found while rebalancing")
+		}
+	}
+	if len(m.Loads()) < 3 {
+		t.Error("expecting splits")
+	}
+	seen := make(map[string]bool)
+	for _, key := range m.Keys() {
+		if seen[key] {
+			t.Error("expecting no item duplicated", key)
+		}
+		seen[key] = true
+	}
+	for i := 0; i < 200; i++ {
+		if !seen[fmt.Sprint(i)] {
+			t.Error("expecting no item lost", i)
+		}
+	}
+}
+
+func TestSplitHottestFrozen(t *testing.T) {
+	t.Parallel()
+	m := NewMultiLRUCache(2, 100)
+	for i := 0; i < 20; i++ {
+		m.Set(fmt.Sprint(i), i, time.Time{})
+	}
+
+	m.Freeze()
+	done := make(chan bool)
+	go func() {
+		split := m.SplitHottest()
+		m.Unfreeze()
+		done <- split
+	}()
+	select {
+	case split := <-done:
+		if split {
+			t.Error("expecting no split while frozen")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expecting SplitHottest not to block Unfreeze")
+	}
+	if !m.SplitHottest() {
+		t.Error("expecting split once unfrozen")
+	}
+}
+
+func TestSplitHottestDefaultTTL(t *testing.T) {
+	t.Parallel()
+	m := NewMultiLRUCache(2, 100)
+	m.SetDefaultTTL(time.Hour)
+	m.Get("a")
+	if !m.SplitHottest() {
+		t.Error("expecting split")
+	}
+	for i := 0; i < 40; i++ {
+		key := fmt.Sprint(i)
+		m.SetDefault(key, i)
+		if ttl, ok := m.TTL(key); !ok || ttl == lrucache.NoExpiry || ttl > time.Hour {
+			t.Error("expecting default TTL in every bucket", key, ttl)
+		}
+	}
+}
+
+func TestDrainSplit(t *testing.T) {
+	t.Parallel()
+	m := NewMultiLRUCache(2, 100)
+	for i := 0; i < 20; i++ {
+		m.Set(fmt.Sprint(i), i, time.Time{})
+	}
+
+	drained := m.Drain()
+	if !m.SplitHottest() {
+		t.Error("expecting split")
+	}
+	for i := 0; i < 40; i++ {
+		if err := m.Set(fmt.Sprint("new", i), i, time.Time{}); err != lrucache.ErrDraining {
+			t.Error("expecting every bucket draining", err)
+		}
+	}
+	// Keep one of the items moved to a new bucket for last.
+	last := ""
+	for i := 0; i < 20; i++ {
+		if key := fmt.Sprint(i); last == "" && m.bucketNo(key) >= 2 {
+			last = key
+		} else {
+			m.Del(key)
+		}
+	}
+	select {
+	case <-drained:
+		t.Error("expecting drain to wait for the new buckets")
+	case <-time.After(10 * time.Millisecond):
+	}
+	m.Del(last)
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		t.Error("expecting drained once empty")
+	}
+}
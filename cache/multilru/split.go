@@ -0,0 +1,90 @@
+package multilru
+
+import (
+	"hash/crc32"
+)
+
+// Split the bucket with the most operations since the last split, to
+// spread a hot part of the key space over more buckets. Only that
+// bucket is split in two, only its keys are rehashed: the new bucket
+// gets the capacity, options and default TTL of the original one, so
+// the total capacity grows. Items are moved to the new bucket keeping
+// their LRU order, expiry, expiry class and Acquire pins, with both
+// buckets locked, so every item is found in one of them at any time.
+// Operation counters are reset afterwards.
+// Returns false if there were no operations to pick the hottest
+// bucket from, or if the cache is frozen: moving the items would wait
+// for Unfreeze, which waits for the split.
+//
+// Blocks all the operations on the slot while the items are moved,
+// and all the operations over many buckets. O(bucket size)
+func (m *MultiLRUCache) SplitHottest() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	// Freeze and Unfreeze hold the lock for reading, the state can't
+	// change until the split is done.
+	if m.frozen.Load() {
+		return false
+	}
+
+	var hot *bucket
+	for _, bk := range m.all {
+		if hot == nil || bk.ops.Load() > hot.ops.Load() {
+			hot = bk
+		}
+	}
+	if hot == nil || hot.ops.Load() == 0 {
+		return false
+	}
+
+	s := &m.slots[hot.slot]
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if 1<<hot.depth == len(s.leaves) {
+		// The bucket is at a single index, double them.
+		leaves := make([]*bucket, 2*len(s.leaves))
+		copy(leaves, s.leaves)
+		copy(leaves[len(s.leaves):], s.leaves)
+		s.leaves = leaves
+	}
+	// Keys of the bucket with this bit set in the leaf index move.
+	bit := uint(1) << hot.depth
+	hot.depth += 1
+	split := m.newBucket(hot.slot)
+	split.depth = hot.depth
+	// Runtime settings, the options are applied by newBucket.
+	split.cache.SetDefaultTTL(hot.cache.DefaultTTL())
+	for i, bk := range s.leaves {
+		if bk == hot && uint(i)&bit != 0 {
+			s.leaves[i] = split
+		}
+	}
+	hot.cache.MoveTo(split.cache, func(key string) bool {
+		h := uint(crc32.ChecksumIEEE([]byte(key)))
+		return leafNo(h/m.buckets, len(s.leaves))&bit != 0
+	})
+	if m.draining.Load() {
+		// Only now, a draining bucket wouldn't take the moved items.
+		split.cache.Drain()
+	}
+
+	for _, bk := range m.all {
+		bk.ops.Store(0)
+	}
+	return true
+}
+
+// Number of operations on every bucket since the last split, indexed
+// like bucket numbers. Only operations on a single key are counted.
+func (m *MultiLRUCache) Loads() []uint64 {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	loads := make([]uint64, len(m.all))
+	for i, bk := range m.all {
+		loads[i] = bk.ops.Load()
+	}
+	return loads
+}
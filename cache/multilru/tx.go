@@ -0,0 +1,82 @@
+package multilru
+
+import (
+	"sort"
+	"time"
+
+	"github.com/majek/goplayground/cache/lrucache"
+)
+
+// Operations on the buckets locked by WithLock. Using a key from a
+// bucket that is not locked panics.
+type Tx struct {
+	m   *MultiLRUCache
+	txs map[uint]*lrucache.Tx
+}
+
+func (tx *Tx) bucket(key string) *lrucache.Tx {
+	t := tx.txs[tx.m.bucketNo(key)]
+	if t == nil {
+		panic("multilru: key outside of the locked buckets")
+	}
+	return t
+}
+
+func (tx *Tx) Get(key string) (value interface{}, ok bool) {
+	return tx.bucket(key).Get(key)
+}
+
+func (tx *Tx) Set(key string, value interface{}, expire time.Time) error {
+	return tx.bucket(key).Set(key, value, expire)
+}
+
+func (tx *Tx) Del(key string) (value interface{}, ok bool) {
+	return tx.bucket(key).Del(key)
+}
+
+// Run `fn` with the buckets of all the `keys` locked, see
+// lrucache.WithLock. Buckets are always locked in increasing order, so
+// concurrent WithLock calls can't deadlock; nesting WithLock calls
+// can, don't. SplitHottest waits for WithLock to finish.
+func (m *MultiLRUCache) WithLock(keys []string, fn func(tx *Tx)) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	seen := make(map[uint]bool)
+	var buckets []uint
+	for _, key := range keys {
+		if no := m.bucketNo(key); !seen[no] {
+			seen[no] = true
+			buckets = append(buckets, no)
+		}
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+
+	tx := &Tx{m: m, txs: make(map[uint]*lrucache.Tx, len(buckets))}
+	var lock func(i int)
+	lock = func(i int) {
+		if i == len(buckets) {
+			fn(tx)
+			return
+		}
+		m.cache[buckets[i]].WithLock(func(t *lrucache.Tx) {
+			tx.txs[buckets[i]] = t
+			lock(i + 1)
+		})
+	}
+	lock(0)
+}
+
+// Rename a key, see lrucache.Rename. If the keys live in different
+// buckets the item is moved between them with both locked: it keeps
+// its value and expiry, but becomes a new item in its new bucket and
+// loses its LRU position. Returns false if there is no `oldKey`, or
+// if the new bucket doesn't take the item, because it's full (see
+// lrucache.WithCanEvict) or draining for example, in which case the
+// item stays at `oldKey`.
+func (m *MultiLRUCache) Rename(oldKey, newKey string) (ok bool) {
+	m.WithLock([]string{oldKey, newKey}, func(tx *Tx) {
+		ok = tx.bucket(oldKey).MoveTo(tx.bucket(newKey), oldKey, newKey)
+	})
+	return ok
+}